@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/history"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func registerHistoryAPI(store *history.Store) {
+	http.HandleFunc("/api/history", func(w http.ResponseWriter, request *http.Request) {
+		query, err := historyQuery(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var step time.Duration
+		if s := request.URL.Query().Get("step"); s != "" {
+			step, err = time.ParseDuration(s)
+			if err != nil || step < 0 {
+				http.Error(w, "'step' parameter must be a positive duration", http.StatusBadRequest)
+				return
+			}
+		}
+		summaries, err := store.Range(query, step)
+		if err != nil {
+			historyError(w, err)
+			return
+		}
+		writeJSON(w, summaries)
+	})
+
+	http.HandleFunc("/api/history/targets", func(w http.ResponseWriter, request *http.Request) {
+		query, err := historyQuery(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		targets, err := store.Targets(query)
+		if err != nil {
+			historyError(w, err)
+			return
+		}
+		writeJSON(w, targets)
+	})
+
+	http.HandleFunc("/api/history/percentiles", func(w http.ResponseWriter, request *http.Request) {
+		query, err := historyQuery(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		quantiles := []float64{0.5, 0.9, 0.99}
+		if q := request.URL.Query().Get("quantiles"); q != "" {
+			quantiles = nil
+			for _, s := range strings.Split(q, ",") {
+				quantile, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+				if err != nil || quantile < 0 || quantile > 1 {
+					http.Error(w, "'quantiles' parameter must be a list of numbers between 0 and 1", http.StatusBadRequest)
+					return
+				}
+				quantiles = append(quantiles, quantile)
+			}
+		}
+		percentiles, err := store.Percentiles(query, quantiles)
+		if err != nil {
+			historyError(w, err)
+			return
+		}
+		writeJSON(w, percentiles)
+	})
+}
+
+// historyQuery parses target, module, from and to. Times are RFC 3339 or Unix
+// timestamps and default to the last 24 hours.
+func historyQuery(request *http.Request) (history.Query, error) {
+	query := history.Query{
+		Target: request.URL.Query().Get("target"),
+		Module: request.URL.Query().Get("module"),
+		To:     time.Now(),
+	}
+
+	var err error
+	if to := request.URL.Query().Get("to"); to != "" {
+		query.To, err = parseTime(to)
+		if err != nil {
+			return query, fmt.Errorf("'to' parameter must be RFC 3339 or Unix timestamp")
+		}
+	}
+	query.From = query.To.Add(-24 * time.Hour)
+	if from := request.URL.Query().Get("from"); from != "" {
+		query.From, err = parseTime(from)
+		if err != nil {
+			return query, fmt.Errorf("'from' parameter must be RFC 3339 or Unix timestamp")
+		}
+	}
+	return query, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func historyError(w http.ResponseWriter, err error) {
+	log.WithFields(log.Fields{
+		"err": err,
+	}).Error("History query failed")
+	http.Error(w, "History query failed", http.StatusInternalServerError)
+}
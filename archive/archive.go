@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	filePrefix = "iperf3-"
+	fileSuffix = ".jsonl"
+)
+
+type Options struct {
+	Dir string
+	// MaxFileSize and MaxFileAge trigger the rotation of the current file.
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	// Rotated files are deleted once they are older than Retention or the
+	// archive exceeds MaxTotalSize. Zero disables the respective limit.
+	Retention    time.Duration
+	MaxTotalSize int64
+}
+
+// Archive appends probe records as JSON lines to rotating files.
+type Archive struct {
+	opts Options
+
+	// now returns the current time, replaced by tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func Open(opts Options) (*Archive, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	a := &Archive{opts: opts, now: time.Now}
+	if err := a.prune(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Archive) Write(record *collector.Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil || a.shouldRotate(int64(len(line))) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+func (a *Archive) shouldRotate(next int64) bool {
+	if a.opts.MaxFileSize > 0 && a.size > 0 && a.size+next > a.opts.MaxFileSize {
+		return true
+	}
+	return a.opts.MaxFileAge > 0 && a.now().Sub(a.openedAt) > a.opts.MaxFileAge
+}
+
+func (a *Archive) rotate() error {
+	if a.file != nil {
+		if err := a.file.Close(); err != nil {
+			return err
+		}
+		a.file = nil
+	}
+
+	now := a.now().UTC()
+	name := filepath.Join(a.opts.Dir, filePrefix+now.Format("20060102T150405.000000000Z")+fileSuffix)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a.file = file
+	a.size = 0
+	a.openedAt = now
+
+	if err := a.prune(); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Pruning archive failed")
+	}
+	return nil
+}
+
+// prune deletes rotated files that exceed the retention limits, oldest first.
+func (a *Archive) prune() error {
+	files, err := Files(a.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	infos := make([]os.FileInfo, len(files))
+	for i, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		infos[i] = info
+		total += info.Size()
+	}
+
+	for i, name := range files {
+		if a.file != nil && name == a.file.Name() {
+			continue
+		}
+		expired := a.opts.Retention > 0 && a.now().Sub(infos[i].ModTime()) > a.opts.Retention
+		oversized := a.opts.MaxTotalSize > 0 && total > a.opts.MaxTotalSize
+		if !expired && !oversized {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+		total -= infos[i].Size()
+		log.WithFields(log.Fields{
+			"file": name,
+		}).Debug("Removed archive file")
+	}
+	return nil
+}
+
+// Files returns the archive files in dir, oldest first.
+func Files(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Read calls fn for every record in r. A last line that is cut short, as
+// left by an exporter stopped while writing, is skipped.
+func Read(r io.Reader, fn func(*collector.Record) error) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		content, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		complete := err == nil
+		if content = bytes.TrimSpace(content); len(content) > 0 {
+			record := &collector.Record{}
+			if err := json.Unmarshal(content, record); err != nil {
+				if !complete {
+					return nil
+				}
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if !complete {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"encoding/json"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// testArchive returns an archive in a temporary directory, running on a
+// fake clock.
+func testArchive(t *testing.T, opts Options) (*Archive, *fakeClock) {
+	opts.Dir = t.TempDir()
+	clock := &fakeClock{now: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)}
+	a := &Archive{opts: opts, now: clock.Now}
+	t.Cleanup(func() { a.Close() })
+	return a, clock
+}
+
+func testRecord(target string) *collector.Record {
+	return &collector.Record{
+		Target:  target,
+		Module:  "download",
+		Results: &collector.Iperf3Results{},
+	}
+}
+
+// readAll returns the targets of the records in the archive files.
+func readAll(t *testing.T, dir string) [][]string {
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets [][]string
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var inFile []string
+		err = Read(file, func(record *collector.Record) error {
+			inFile = append(inFile, record.Target)
+			return nil
+		})
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, inFile)
+	}
+	return targets
+}
+
+func TestRotateBySize(t *testing.T) {
+	// Room for two records, a line each, per file.
+	line, err := json.Marshal(testRecord("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, clock := testArchive(t, Options{MaxFileSize: 2 * int64(len(line)+1)})
+	for _, target := range []string{"a", "b", "c", "d", "e"} {
+		if err := a.Write(testRecord(target)); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+	a.Close()
+
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if got := readAll(t, a.opts.Dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("records per file = %v, want %v", got, want)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	a, clock := testArchive(t, Options{MaxFileAge: time.Hour})
+	for _, step := range []struct {
+		target  string
+		advance time.Duration
+	}{
+		{"a", 0},
+		{"b", 30 * time.Minute},
+		{"c", 31 * time.Minute},
+		{"d", 10 * time.Minute},
+	} {
+		clock.Advance(step.advance)
+		if err := a.Write(testRecord(step.target)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close()
+
+	want := [][]string{{"a", "b"}, {"c", "d"}}
+	if got := readAll(t, a.opts.Dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("records per file = %v, want %v", got, want)
+	}
+}
+
+// writeFile creates an archive file named after its creation time with
+// size bytes, last modified at modified.
+func writeFile(t *testing.T, dir string, created time.Time, size int, modified time.Time) string {
+	name := filepath.Join(dir, filePrefix+created.UTC().Format("20060102T150405.000000000Z")+fileSuffix)
+	if err := ioutil.WriteFile(name, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestPruneRetention(t *testing.T) {
+	a, clock := testArchive(t, Options{Retention: 24 * time.Hour})
+	now := clock.Now()
+	old := writeFile(t, a.opts.Dir, now.Add(-72*time.Hour), 10, now.Add(-48*time.Hour))
+	recent := writeFile(t, a.opts.Dir, now.Add(-48*time.Hour), 10, now.Add(-time.Hour))
+
+	if err := a.prune(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Files(a.opts.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{recent}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files after pruning = %v, want %v without %s", files, want, old)
+	}
+}
+
+func TestPruneTotalSize(t *testing.T) {
+	a, clock := testArchive(t, Options{MaxTotalSize: 250})
+	now := clock.Now()
+	oldest := writeFile(t, a.opts.Dir, now.Add(-3*time.Hour), 100, now)
+	older := writeFile(t, a.opts.Dir, now.Add(-2*time.Hour), 100, now)
+	newer := writeFile(t, a.opts.Dir, now.Add(-time.Hour), 100, now)
+
+	// Rotating opens the current file, which is never pruned, and drops
+	// the oldest files until the archive fits.
+	if err := a.Write(testRecord("a")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Files(a.opts.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0] != older || files[1] != newer || files[2] != a.file.Name() {
+		t.Errorf("files after pruning = %v, want %s, %s and the current file without %s", files, older, newer, oldest)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"iperf3-20210302T000000.000000000Z.jsonl",
+		"iperf3-20210301T120000.000000000Z.jsonl",
+		"iperf3-20210301T120000.500000000Z.jsonl",
+		"iperf3-20210301T000000.000000000Z.jsonl.gz",
+		"other.jsonl",
+		"README",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "iperf3-dir.jsonl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "iperf3-20210301T120000.000000000Z.jsonl"),
+		filepath.Join(dir, "iperf3-20210301T120000.500000000Z.jsonl"),
+		filepath.Join(dir, "iperf3-20210302T000000.000000000Z.jsonl"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Files() = %v, want %v", files, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	record := `{"target":"a","module":"m","start":"2021-03-01T12:00:00Z","end":"2021-03-01T12:00:10Z","results":{}}`
+	for _, test := range []struct {
+		name    string
+		content string
+		targets []string
+		err     string
+	}{
+		{"complete", record + "\n" + strings.Replace(record, `"a"`, `"b"`, 1) + "\n", []string{"a", "b"}, ""},
+		{"without final newline", record, []string{"a"}, ""},
+		{"empty lines", "\n" + record + "\n\n", []string{"a"}, ""},
+		{"cut short", record + "\n" + record[:40], []string{"a"}, ""},
+		{"malformed line", record + "\n" + record[:40] + "\n" + record + "\n", []string{"a"}, "line 2"},
+	} {
+		var targets []string
+		err := Read(strings.NewReader(test.content), func(record *collector.Record) error {
+			targets = append(targets, record.Target)
+			return nil
+		})
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error = %v, want it to contain %q", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(targets, test.targets) {
+			t.Errorf("%s: read %v, want %v", test.name, targets, test.targets)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketWidth is the granularity of the rolling windows.
+const bucketWidth = time.Hour
+
+var (
+	limitDesc     = prometheus.NewDesc("iperf3_budget_limit_bytes", "Bytes probes may transfer within the window of a budget.", []string{"target", "window"}, nil)
+	usedDesc      = prometheus.NewDesc("iperf3_budget_used_bytes", "Bytes transferred by probes within the window of a budget.", []string{"target", "window"}, nil)
+	remainingDesc = prometheus.NewDesc("iperf3_budget_remaining_bytes", "Bytes probes may still transfer within the window of a budget.", []string{"target", "window"}, nil)
+)
+
+// Tracker accounts the bytes transferred by probes in hourly buckets per
+// target and enforces the configured budgets. A target without budget of its
+// own still counts towards the global budgets.
+type Tracker struct {
+	budgets []*config.Budget
+	path    string
+
+	mu    sync.Mutex
+	usage map[string]map[int64]int64 // target, bucket start in Unix seconds
+
+	enforcedTotal *prometheus.CounterVec
+}
+
+// Open restores the usage from the state file at path, if any. With an empty
+// path the usage is only kept in memory.
+func Open(path string, budgets []*config.Budget) (*Tracker, error) {
+	t := &Tracker{
+		budgets: budgets,
+		path:    path,
+		usage:   map[string]map[int64]int64{},
+		enforcedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "iperf3_budget_enforced_probes_total",
+			Help: "Probes blocked or downgraded because a budget was exceeded.",
+		}, []string{"action"}),
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &t.usage); err != nil {
+		return nil, fmt.Errorf("parsing budget state %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Bytes returns the bytes moved by a probe: those sent by forward tests and
+// those received by reverse tests, like the exporter's tx and rx counters.
+func Bytes(r *collector.Iperf3Results) int64 {
+	if r.End == nil {
+		return 0
+	}
+	reverse := r.Start != nil && r.Start.TestStart != nil && r.Start.TestStart.Reverse > 0
+	// UDP tests of iperf3 before 3.13 only report a summary of both ends.
+	switch {
+	case reverse && r.End.SummaryReceived != nil:
+		return int64(r.End.SummaryReceived.Bytes)
+	case !reverse && r.End.SummarySent != nil:
+		return int64(r.End.SummarySent.Bytes)
+	case r.End.Summary != nil:
+		return int64(r.End.Summary.Bytes)
+	}
+	return 0
+}
+
+// Write accounts the bytes of a test and persists the usage.
+func (t *Tracker) Write(record *collector.Record) error {
+	bytes := Bytes(record.Results)
+	if bytes == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buckets, ok := t.usage[record.Target]
+	if !ok {
+		buckets = map[int64]int64{}
+		t.usage[record.Target] = buckets
+	}
+	buckets[record.End.Truncate(bucketWidth).Unix()] += bytes
+	t.prune(time.Now())
+	return t.save()
+}
+
+// prune removes buckets outside of every window. t.mu must be held.
+func (t *Tracker) prune(now time.Time) {
+	var longest time.Duration
+	for _, b := range t.budgets {
+		if b.Window > longest {
+			longest = b.Window
+		}
+	}
+	oldest := now.Add(-longest).Truncate(bucketWidth).Unix()
+	for target, buckets := range t.usage {
+		for start := range buckets {
+			if start < oldest {
+				delete(buckets, start)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(t.usage, target)
+		}
+	}
+}
+
+func (t *Tracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+	content, err := json.Marshal(t.usage)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(t.path+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(t.path+".tmp", t.path)
+}
+
+// used sums the bytes within the window of b. t.mu must be held.
+func (t *Tracker) used(b *config.Budget, now time.Time) int64 {
+	oldest := now.Add(-b.Window).Truncate(bucketWidth).Unix()
+	var sum int64
+	for target, buckets := range t.usage {
+		if b.Target != "" && target != b.Target {
+			continue
+		}
+		for start, bytes := range buckets {
+			if start >= oldest {
+				sum += bytes
+			}
+		}
+	}
+	return sum
+}
+
+// Exceeded returns the exceeded budget applying to target, preferring one
+// that blocks over one that downgrades, or nil if probes may run as usual.
+func (t *Tracker) Exceeded(target string) *config.Budget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	var exceeded *config.Budget
+	for _, b := range t.budgets {
+		if b.Target != "" && b.Target != target {
+			continue
+		}
+		if t.used(b, now) < b.Bytes {
+			continue
+		}
+		if b.Action == config.BudgetActionBlock {
+			return b
+		}
+		if exceeded == nil {
+			exceeded = b
+		}
+	}
+	return exceeded
+}
+
+// Enforced counts a probe that was blocked or downgraded by b.
+func (t *Tracker) Enforced(b *config.Budget) {
+	t.enforcedTotal.WithLabelValues(b.Action).Inc()
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- limitDesc
+	ch <- usedDesc
+	ch <- remainingDesc
+	t.enforcedTotal.Describe(ch)
+}
+
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	now := time.Now()
+	for _, b := range t.budgets {
+		used := t.used(b, now)
+		remaining := b.Bytes - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		window := formatWindow(b.Window)
+		ch <- prometheus.MustNewConstMetric(limitDesc, prometheus.GaugeValue, float64(b.Bytes), b.Target, window)
+		ch <- prometheus.MustNewConstMetric(usedDesc, prometheus.GaugeValue, float64(used), b.Target, window)
+		ch <- prometheus.MustNewConstMetric(remainingDesc, prometheus.GaugeValue, float64(remaining), b.Target, window)
+	}
+	t.mu.Unlock()
+	t.enforcedTotal.Collect(ch)
+}
+
+// formatWindow formats whole hours without the zero minutes and seconds.
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "h0m0s") {
+		return strings.TrimSuffix(s, "0m0s")
+	}
+	return s
+}
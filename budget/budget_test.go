@@ -0,0 +1,176 @@
+package budget
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecord(target string, bytes int, end time.Time) *collector.Record {
+	return &collector.Record{
+		Target: target,
+		End:    end,
+		Results: &collector.Iperf3Results{
+			End: &collector.Iperf3End{
+				SummarySent: &collector.Iperf3SummarySent{Bytes: bytes},
+			},
+		},
+	}
+}
+
+func TestBytes(t *testing.T) {
+	both := &collector.Iperf3End{
+		SummarySent:     &collector.Iperf3SummarySent{Bytes: 200},
+		SummaryReceived: &collector.Iperf3SummaryReceived{Bytes: 100},
+	}
+	for _, test := range []struct {
+		name    string
+		reverse bool
+		end     *collector.Iperf3End
+		want    int64
+	}{
+		{"no end", false, nil, 0},
+		{"sent", false, &collector.Iperf3End{SummarySent: &collector.Iperf3SummarySent{Bytes: 100}}, 100},
+		{"forward", false, both, 200},
+		{"reverse", true, both, 100},
+		{"udp", false, &collector.Iperf3End{Summary: &collector.Iperf3SummaryUDP{Bytes: 300}}, 300},
+		{"reverse udp", true, &collector.Iperf3End{Summary: &collector.Iperf3SummaryUDP{Bytes: 300}}, 300},
+	} {
+		start := &collector.Iperf3Start{TestStart: &collector.Iperf3TestStart{}}
+		if test.reverse {
+			start.TestStart.Reverse = 1
+		}
+		if got := Bytes(&collector.Iperf3Results{Start: start, End: test.end}); got != test.want {
+			t.Errorf("%s: Bytes() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTrackerExceeded(t *testing.T) {
+	global := &config.Budget{Window: time.Hour, Bytes: 1000, Action: config.BudgetActionDowngrade, Module: "small"}
+	perTarget := &config.Budget{Target: "a", Window: time.Hour, Bytes: 500, Action: config.BudgetActionBlock}
+	tracker, err := Open("", []*config.Budget{global, perTarget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for _, step := range []struct {
+		target string
+		bytes  int
+		a, b   *config.Budget
+	}{
+		{"a", 400, nil, nil},
+		{"a", 200, perTarget, nil},
+		// The global budget is exceeded as well, blocking is preferred.
+		{"b", 500, perTarget, global},
+	} {
+		if err := tracker.Write(testRecord(step.target, step.bytes, now)); err != nil {
+			t.Fatal(err)
+		}
+		if got := tracker.Exceeded("a"); got != step.a {
+			t.Errorf("after %d bytes to %s: Exceeded(a) = %v, want %v", step.bytes, step.target, got, step.a)
+		}
+		if got := tracker.Exceeded("b"); got != step.b {
+			t.Errorf("after %d bytes to %s: Exceeded(b) = %v, want %v", step.bytes, step.target, got, step.b)
+		}
+	}
+}
+
+func TestTrackerWindow(t *testing.T) {
+	hour := &config.Budget{Window: time.Hour, Bytes: 1000}
+	day := &config.Budget{Window: 24 * time.Hour, Bytes: 1000}
+	tracker, err := Open("", []*config.Budget{hour, day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, record := range []*collector.Record{
+		testRecord("a", 100, now),
+		testRecord("a", 200, now.Add(-3*time.Hour)),
+		testRecord("b", 400, now.Add(-12*time.Hour)),
+		// Outside of every window, pruned.
+		testRecord("c", 800, now.Add(-48*time.Hour)),
+	} {
+		if err := tracker.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := tracker.used(hour, now); got != 100 {
+		t.Errorf("used within 1h = %d, want 100", got)
+	}
+	if got := tracker.used(day, now); got != 700 {
+		t.Errorf("used within 24h = %d, want 700", got)
+	}
+	if _, ok := tracker.usage["c"]; ok {
+		t.Error("usage older than every window not pruned")
+	}
+}
+
+func TestTrackerState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	budgets := []*config.Budget{{Window: time.Hour, Bytes: 1000, Action: config.BudgetActionBlock}}
+	tracker, err := Open(path, budgets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Write(testRecord("a", 1500, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := Open(path, budgets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Exceeded("a"); got != budgets[0] {
+		t.Errorf("Exceeded(a) after restoring = %v, want the budget", got)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, budgets); err == nil {
+		t.Error("Open succeeded on a corrupt state file, want error")
+	}
+}
+
+func TestTrackerCollect(t *testing.T) {
+	tracker, err := Open("", []*config.Budget{
+		{Window: 24 * time.Hour, Bytes: 1000, Action: config.BudgetActionBlock},
+		{Target: "a", Window: 90 * time.Minute, Bytes: 100, Action: config.BudgetActionBlock},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Write(testRecord("a", 300, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Enforced(tracker.Exceeded("a"))
+
+	expected := `
+# HELP iperf3_budget_enforced_probes_total Probes blocked or downgraded because a budget was exceeded.
+# TYPE iperf3_budget_enforced_probes_total counter
+iperf3_budget_enforced_probes_total{action="block"} 1
+# HELP iperf3_budget_limit_bytes Bytes probes may transfer within the window of a budget.
+# TYPE iperf3_budget_limit_bytes gauge
+iperf3_budget_limit_bytes{target="",window="24h"} 1000
+iperf3_budget_limit_bytes{target="a",window="1h30m0s"} 100
+# HELP iperf3_budget_remaining_bytes Bytes probes may still transfer within the window of a budget.
+# TYPE iperf3_budget_remaining_bytes gauge
+iperf3_budget_remaining_bytes{target="",window="24h"} 700
+iperf3_budget_remaining_bytes{target="a",window="1h30m0s"} 0
+# HELP iperf3_budget_used_bytes Bytes transferred by probes within the window of a budget.
+# TYPE iperf3_budget_used_bytes gauge
+iperf3_budget_used_bytes{target="",window="24h"} 300
+iperf3_budget_used_bytes{target="a",window="1h30m0s"} 300
+`
+	if err := testutil.CollectAndCompare(tracker, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/budget"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var budgets *budget.Tracker
+
+func setupBudgets() {
+	if len(conf.Budgets) == 0 {
+		return
+	}
+	tracker, err := budget.Open(*budgetStateFile, conf.Budgets)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Could not open budget state")
+	}
+	prometheus.MustRegister(tracker)
+	budgets = tracker
+}
+
+// downgrade returns the module to probe target with, which differs from the
+// requested one if a budget with action downgrade is exceeded.
+func downgrade(target string, moduleName string, module *config.Module) (string, *config.Module) {
+	if budgets == nil {
+		return moduleName, module
+	}
+	b := budgets.Exceeded(target)
+	if b == nil || b.Action != config.BudgetActionDowngrade || b.Module == moduleName {
+		return moduleName, module
+	}
+	budgets.Enforced(b)
+	log.WithFields(log.Fields{
+		"target":           target,
+		"module":           moduleName,
+		"downgrade_module": b.Module,
+		"window":           b.Window,
+	}).Info("Traffic budget exceeded, downgrading probe")
+	return b.Module, conf.Modules[b.Module]
+}
+
+// admit refuses probes of target while a budget with action block is exceeded.
+func admit(target string) error {
+	b := budgets.Exceeded(target)
+	if b == nil || b.Action != config.BudgetActionBlock {
+		return nil
+	}
+	budgets.Enforced(b)
+	if b.Target == "" {
+		return fmt.Errorf("global traffic budget of %d bytes per %s exceeded", b.Bytes, b.Window)
+	}
+	return fmt.Errorf("traffic budget of %d bytes per %s for %s exceeded", b.Bytes, b.Window, b.Target)
+}
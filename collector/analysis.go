@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bdpDesc             *prometheus.Desc
+	effectiveWindowDesc *prometheus.Desc
+	windowLimitedDesc   *prometheus.Desc
+
+	transferDurationDesc *prometheus.Desc
+)
+
+func init() {
+	bdpDesc = prometheus.NewDesc("iperf3_bdp_bytes", "Bandwidth-delay product of the measured throughput and mean round trip time", nil, nil)
+	effectiveWindowDesc = prometheus.NewDesc("iperf3_effective_window_bytes", "Smaller of the actual send and receive buffer sizes, summed over all streams", nil, nil)
+	windowLimitedDesc = prometheus.NewDesc("iperf3_window_limited_bool", "1 if the effective window is smaller than the bandwidth-delay product", nil, nil)
+
+	transferDurationDesc = prometheus.NewDesc("iperf3_transfer_duration_seconds", "Time the receiver took to receive the bytes or blocks of a fixed-size transfer", nil, nil)
+}
+
+func describeAnalysis(ch chan<- *prometheus.Desc) {
+	ch <- bdpDesc
+	ch <- effectiveWindowDesc
+	ch <- windowLimitedDesc
+
+	ch <- transferDurationDesc
+}
+
+// reportAnalysis reports metrics derived from the results. TCP tests without
+// round trip times, e.g. from hosts other than Linux, have no bandwidth-delay
+// product.
+func reportAnalysis(r *Iperf3Results, ch chan<- prometheus.Metric) {
+	if r.Start.TestStart != nil && (r.Start.TestStart.Bytes > 0 || r.Start.TestStart.Blocks > 0) && r.End.SummaryReceived != nil {
+		ch <- prometheus.MustNewConstMetric(transferDurationDesc, prometheus.GaugeValue, r.End.SummaryReceived.Seconds)
+	}
+
+	product, ok := bdp(r)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(bdpDesc, prometheus.GaugeValue, product)
+
+	// Without a window set, the buffers are tuned by the kernel during the
+	// test and the reported sizes are only the initial ones.
+	if r.Start.SocketBufferSize == 0 {
+		return
+	}
+	window := float64(effectiveWindow(r))
+	ch <- prometheus.MustNewConstMetric(effectiveWindowDesc, prometheus.GaugeValue, window)
+	ch <- prometheus.MustNewConstMetric(windowLimitedDesc, prometheus.GaugeValue, boolToFloat(window < product))
+}
+
+// bdp returns the bandwidth-delay product in bytes of the throughput
+// received and the mean round trip time of the streams.
+func bdp(r *Iperf3Results) (float64, bool) {
+	rtt, ok := meanRoundTripTime(r)
+	if !ok || r.End.SummaryReceived == nil {
+		return 0, false
+	}
+	return r.End.SummaryReceived.BitsPerSecond / 8 * rtt, true
+}
+
+// meanRoundTripTime returns the mean round trip time in seconds over the
+// sending ends of all streams.
+func meanRoundTripTime(r *Iperf3Results) (float64, bool) {
+	if r.End == nil {
+		return 0, false
+	}
+	var sum float64
+	var n int
+	for _, stream := range r.End.Streams {
+		if stream.Sender == nil || stream.Sender.MeanRoundTripTime <= 0 {
+			continue
+		}
+		sum += stream.Sender.MeanRoundTripTime
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n) / 1000000, true
+}
+
+// effectiveWindow returns the window in bytes all streams together can keep
+// in flight, as limited by the socket buffers.
+func effectiveWindow(r *Iperf3Results) int {
+	window := r.Start.SendBufferSize
+	if r.Start.ReceiveBufferSize < window {
+		window = r.Start.ReceiveBufferSize
+	}
+	streams := 1
+	if r.Start.TestStart != nil && r.Start.TestStart.NumStreams > 1 {
+		streams = r.Start.TestStart.NumStreams
+	}
+	return window * streams
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,28 +1,111 @@
 package collector
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"io/ioutil"
+	"net"
 	"os/exec"
 	"strconv"
 	"time"
 )
 
+var tracer = otel.Tracer("github.com/fluepke/iperf3-exporter/collector")
+
 type Collector struct {
 	Timeout      time.Duration
 	Iperf3Path   string
 	Target       string
+	Module       string
+	Labels       map[string]string
 	Duration     time.Duration
 	OmitDuration time.Duration
 	MSS          int
 	Reverse      bool
-
-	ErrorCounter prometheus.Counter
-	RxCounter    prometheus.Gauge
-	TxCounter    prometheus.Gauge
+	JSONStream   bool
+	// Bytes or Blocks transfer a fixed amount instead of testing for
+	// Duration.
+	Bytes  int64
+	Blocks int
+
+	// Address is the host and optional port iperf3 connects to, if it
+	// differs from Target, e.g. a resolved address of Target.
+	Address string
+	// IPVersion restricts iperf3 to IPv4 or IPv6 if set to 4 or 6.
+	IPVersion int
+	// Port is the server port if Address and Target carry none. BindAddress,
+	// BindDevice and ClientPort select the local end of the connections.
+	Port        int
+	BindAddress string
+	BindDevice  string
+	ClientPort  int
+	// Netns is the path of a network namespace to run iperf3 in.
+	Netns string
+	// TOS is the type of service byte to mark the test traffic with.
+	TOS int
+	// CongestionControl is the TCP congestion control algorithm to use.
+	CongestionControl string
+	// Window sets the socket buffer sizes in bytes.
+	Window int
+	// Parallel is the number of parallel streams, 1 if unset.
+	Parallel int
+	// UDP tests with UDP instead of TCP at Bitrate in bits per second.
+	UDP     bool
+	Bitrate int64
+	// IdleLatency measures the latency before the test to compare it with
+	// the one under load, by connecting to IdleLatencyPort of the server.
+	IdleLatency     bool
+	IdleLatencyPort int
+	// DNSServer (host:port) resolves the name of the server for the idle
+	// latency instead of the system resolver.
+	DNSServer string
+	// RFC6349 evaluates TCP tests against the baseline of the path.
+	RFC6349 *RFC6349
+	// MSSSweep runs a test per MSS to find path MTU problems, instead of a
+	// single test.
+	MSSSweep *MSSSweep
+	// StreamSweep runs a test per number of parallel streams, instead of a
+	// single test.
+	StreamSweep []int
+	// Search searches for the highest UDP bitrate with acceptable loss,
+	// running a test per step, instead of a single test.
+	Search *Search
+
+	// OnEvent is called for every event while the test runs. Setting it
+	// implies JSONStream.
+	OnEvent func(*Iperf3Event)
+
+	// Sinks receive the record of every successful run, which for a search
+	// or sweep is the one of the test selected.
+	Sinks []Sink
+	// Account is called with the record of every successful test, including
+	// each step of a search or sweep, e.g. to count its traffic.
+	Account func(*Record) error
+
+	// Context is the parent of the probe run by Collect, e.g. carrying the
+	// trace of the scrape request. Defaults to context.Background.
+	Context context.Context
+	// Admit is called before the test starts. If it returns an error, the
+	// probe fails without running iperf3.
+	Admit func() error
+	// Queue limits the number of concurrent tests. A probe waits for a free
+	// slot before starting iperf3. Nil means unlimited.
+	Queue chan struct{}
+
+	ErrorCounter     prometheus.Counter
+	RxCounter        prometheus.Counter
+	TxCounter        prometheus.Counter
+	ProbeCounter     prometheus.Counter
+	DurationObserver prometheus.Observer
 }
 
 var (
@@ -30,6 +113,7 @@ var (
 
 	localPortDesc         *prometheus.Desc
 	remotePortDesc        *prometheus.Desc
+	connectionDesc        *prometheus.Desc
 	versionDesc           *prometheus.Desc
 	systemInfoDesc        *prometheus.Desc
 	tcpMssDesc            *prometheus.Desc
@@ -44,6 +128,7 @@ var (
 	bytesDesc      *prometheus.Desc
 	blocksDesc     *prometheus.Desc
 	reverseDesc    *prometheus.Desc
+	tosDesc        *prometheus.Desc
 
 	intervalStreamsSecondsDesc               *prometheus.Desc
 	intervalStreamsBytesDesc                 *prometheus.Desc
@@ -73,6 +158,11 @@ var (
 	sumReceivedSecondsDesc *prometheus.Desc
 	sumReceivedBytesDesc   *prometheus.Desc
 
+	udpJitterDesc      *prometheus.Desc
+	udpPacketsDesc     *prometheus.Desc
+	udpLostPacketsDesc *prometheus.Desc
+	udpLostPercentDesc *prometheus.Desc
+
 	cpuUtilizationPercentHostTotalDesc    *prometheus.Desc
 	cpuUtilizationPercentHostUserDesc     *prometheus.Desc
 	cpuUtilizationPercentHostSystemDesc   *prometheus.Desc
@@ -89,6 +179,7 @@ func init() {
 
 	localPortDesc = prometheus.NewDesc("iperf3_local_port_info", "Local port", []string{"socket", "local_host"}, nil)
 	remotePortDesc = prometheus.NewDesc("iperf3_remote_port_info", "Remote port", []string{"socket", "reote_host"}, nil)
+	connectionDesc = prometheus.NewDesc("iperf3_connection_info", "Local and remote address of a connection", []string{"socket", "local_host", "local_port", "remote_host", "remote_port"}, nil)
 	versionDesc = prometheus.NewDesc("iperf3_version_info", "Iperf3 version information", []string{"version"}, nil)
 	systemInfoDesc = prometheus.NewDesc("iperf3_system_info", "System information", []string{"system_info"}, nil)
 	tcpMssDesc = prometheus.NewDesc("iperf3_tcp_mss_bytes", "TCPP maximum segment size", nil, nil)
@@ -103,6 +194,7 @@ func init() {
 	bytesDesc = prometheus.NewDesc("iperf3_bytes", "Test bytes to transfer", nil, nil)
 	blocksDesc = prometheus.NewDesc("iperf3_blocks_count", "Test blocks to transfer", nil, nil)
 	reverseDesc = prometheus.NewDesc("iperf3_reverse_bool", "Wheter to run test in reverse", nil, nil)
+	tosDesc = prometheus.NewDesc("iperf3_tos", "Type of service byte the test traffic was marked with", nil, nil)
 
 	intervalStreamsLabels := []string{"socket", "start", "end", "omitted", "sender"}
 	intervalStreamsSecondsDesc = prometheus.NewDesc("iperf3_intervals_streams_seconds", "Duration of the interval in seconds", intervalStreamsLabels, nil)
@@ -135,6 +227,11 @@ func init() {
 	sumReceivedSecondsDesc = prometheus.NewDesc("iperf3_sum_received_seconds", "Total receive duration", nil, nil)
 	sumReceivedBytesDesc = prometheus.NewDesc("iperf3_sum_received_bytes", "Total received bytes", nil, nil)
 
+	udpJitterDesc = prometheus.NewDesc("iperf3_udp_jitter_seconds", "UDP packet delay variation", nil, nil)
+	udpPacketsDesc = prometheus.NewDesc("iperf3_udp_packets", "UDP packets sent", nil, nil)
+	udpLostPacketsDesc = prometheus.NewDesc("iperf3_udp_lost_packets", "UDP packets lost", nil, nil)
+	udpLostPercentDesc = prometheus.NewDesc("iperf3_udp_lost_percent", "Percentage of UDP packets lost", nil, nil)
+
 	cpuUtilizationPercentHostTotalDesc = prometheus.NewDesc("iperf3_cpu_utilization_host_total_percent", "CPU utilization host total", nil, nil)
 	cpuUtilizationPercentHostUserDesc = prometheus.NewDesc("iperf3_cpu_utilization_host_user_percent", "CPU utilization host user", nil, nil)
 	cpuUtilizationPercentHostSystemDesc = prometheus.NewDesc("iperf3_cpu_utilization_host_system_percent", "CPU utilization host system", nil, nil)
@@ -147,10 +244,34 @@ func init() {
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch)
+}
+
+// ResultsCollector exposes the metrics of previously obtained results, or
+// a failed probe if Results is nil.
+type ResultsCollector struct {
+	Results *Iperf3Results
+}
+
+func (c *ResultsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describe(ch)
+}
+
+func (c *ResultsCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.Results == nil {
+		ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 1)
+	reportMetrics(c.Results, ch)
+}
+
+func describe(ch chan<- *prometheus.Desc) {
 	ch <- successDesc
 
 	ch <- localPortDesc
 	ch <- remotePortDesc
+	ch <- connectionDesc
 	ch <- versionDesc
 	ch <- systemInfoDesc
 	ch <- tcpMssDesc
@@ -165,6 +286,7 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- bytesDesc
 	ch <- blocksDesc
 	ch <- reverseDesc
+	ch <- tosDesc
 
 	ch <- intervalStreamsSecondsDesc
 	ch <- intervalStreamsBytesDesc
@@ -194,6 +316,11 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- sumReceivedSecondsDesc
 	ch <- sumReceivedBytesDesc
 
+	ch <- udpJitterDesc
+	ch <- udpPacketsDesc
+	ch <- udpLostPacketsDesc
+	ch <- udpLostPercentDesc
+
 	ch <- cpuUtilizationPercentHostTotalDesc
 	ch <- cpuUtilizationPercentHostUserDesc
 	ch <- cpuUtilizationPercentHostSystemDesc
@@ -203,29 +330,132 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 
 	ch <- senderTcpCongestionDesc
 	ch <- receiverTcpCongestionDesc
+
+	describeAnalysis(ch)
+	describeSearch(ch)
+	describeRFC6349(ch)
+	describeLatency(ch)
+	describeMSSSweep(ch)
+	describeStreamSweep(ch)
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, "iperf3.collect")
+	defer span.End()
+
+	results, err := c.Run(ctx)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 0)
+		return
+	}
+
+	_, emitSpan := tracer.Start(ctx, "iperf3.emit_metrics")
+	ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 1)
+	reportMetrics(results, ch)
+	emitSpan.End()
+}
+
+// Run performs a single iperf3 test and returns its parsed results. With
+// Search, MSSSweep or StreamSweep set, it performs the search or sweep and
+// returns the results of the test at the bitrate, MSS or stream count found,
+// along with the steps. Only these results are written to the sinks, not
+// those of every step.
+func (c *Collector) Run(ctx context.Context) (*Iperf3Results, error) {
+	var record *Record
+	var err error
+	switch {
+	case c.Search != nil:
+		record, err = c.search(ctx)
+	case c.MSSSweep != nil:
+		record, err = c.sweepMSS(ctx)
+	case len(c.StreamSweep) > 0:
+		record, err = c.sweepStreams(ctx)
+	default:
+		record, err = c.runTest(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.write(ctx, record)
+	return record.Results, nil
+}
+
+// runTest performs a single iperf3 test and returns its record.
+func (c *Collector) runTest(ctx context.Context) (*Record, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.probe", trace.WithAttributes(
+		attribute.String("iperf3.target", c.Target),
+		attribute.String("iperf3.module", c.Module),
+		attribute.Float64("iperf3.duration", c.Duration.Seconds()),
+		attribute.Int64("iperf3.bytes", c.Bytes),
+		attribute.Int("iperf3.blocks", c.Blocks),
+		attribute.Float64("iperf3.omit_duration", c.OmitDuration.Seconds()),
+		attribute.Int("iperf3.mss", c.MSS),
+		attribute.Int("iperf3.window", c.Window),
+		attribute.Int("iperf3.parallel", c.Parallel),
+		attribute.Bool("iperf3.udp", c.UDP),
+		attribute.Int64("iperf3.bitrate", c.Bitrate),
+		attribute.Bool("iperf3.reverse", c.Reverse),
+		attribute.Int("iperf3.tos", c.TOS),
+		attribute.String("iperf3.congestion_control", c.CongestionControl),
+	))
+	defer span.End()
+
+	c.ProbeCounter.Inc()
+	timer := prometheus.NewTimer(c.DurationObserver)
+	defer timer.ObserveDuration()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
 	defer cancel()
 
-	logger := log.WithFields(log.Fields{
-		"iperf3_path":   c.Iperf3Path,
-		"target":        c.Target,
-		"duration":      c.Duration,
-		"omit_duration": c.OmitDuration,
-		"mss":           c.MSS,
-	})
+	logger := c.logger()
 
-	logger.Debug("Performing iperf3")
+	if err := c.wait(ctx); err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("iperf3 probe failed while queued")
+		c.ErrorCounter.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer c.release()
+
+	if c.Admit != nil {
+		if err := c.Admit(); err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("iperf3 probe refused")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
 
-	args := []string{
-		"-J", "-M", strconv.Itoa(c.MSS), "-t", strconv.FormatFloat(c.Duration.Seconds(), 'f', 0, 64), "-O", strconv.FormatFloat(c.OmitDuration.Seconds(), 'f', 0, 64), "-c", c.Target,
+	var idle time.Duration
+	if c.IdleLatency {
+		var err error
+		idle, err = c.idleLatency(ctx)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Warn("Measuring idle latency failed")
+		}
 	}
-	if c.Reverse {
-		args = append(args, "-R")
+
+	logger.Debug("Performing iperf3")
+
+	start := time.Now()
+	var results *Iperf3Results
+	var err error
+	if c.streaming() {
+		results, err = c.runStream(ctx)
+	} else {
+		results, err = c.runJSON(ctx)
 	}
-	out, err := exec.CommandContext(ctx, c.Iperf3Path, args...).Output()
 
 	logger.Debug("iperf3 done")
 
@@ -233,36 +463,281 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		logger.WithFields(log.Fields{
 			"err": err,
 		}).Error("iperf3 probe failed")
-		ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 0)
 		c.ErrorCounter.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// UDP tests of iperf3 before 3.13 only report a summary of both ends.
+	if results.Start.TestStart.Reverse > 0 {
+		if results.End.SummaryReceived != nil {
+			c.RxCounter.Add(float64(results.End.SummaryReceived.Bytes))
+		} else if results.End.Summary != nil {
+			c.RxCounter.Add(float64(results.End.Summary.Bytes))
+		}
+	} else {
+		if results.End.SummarySent != nil {
+			c.TxCounter.Add(float64(results.End.SummarySent.Bytes))
+		} else if results.End.Summary != nil {
+			c.TxCounter.Add(float64(results.End.Summary.Bytes))
+		}
+	}
+
+	if idle > 0 {
+		results.Latency = newLatencyResult(idle, results)
+	}
+	if c.RFC6349 != nil {
+		results.RFC6349 = c.RFC6349.Evaluate(results)
+	}
+
+	record := &Record{
+		Target:  c.Target,
+		Module:  c.Module,
+		Labels:  c.Labels,
+		Start:   start,
+		End:     time.Now(),
+		Results: results,
+	}
+	if c.Account != nil {
+		if err := c.Account(record); err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Error("Accounting probe record failed")
+		}
+	}
+	return record, nil
+}
+
+// write passes the record to every sink.
+func (c *Collector) write(ctx context.Context, record *Record) {
+	if len(c.Sinks) == 0 {
 		return
 	}
+	_, span := tracer.Start(ctx, "iperf3.write_sinks")
+	defer span.End()
+	for _, sink := range c.Sinks {
+		if err := sink.Write(record); err != nil {
+			c.logger().WithFields(log.Fields{
+				"err": err,
+			}).Error("Writing probe record to sink failed")
+			span.RecordError(err)
+		}
+	}
+}
 
+// wait blocks until a slot in the queue is free.
+func (c *Collector) wait(ctx context.Context) error {
+	if c.Queue == nil {
+		return nil
+	}
+	_, span := tracer.Start(ctx, "iperf3.queue")
+	defer span.End()
+	select {
+	case c.Queue <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		span.SetStatus(codes.Error, ctx.Err().Error())
+		return ctx.Err()
+	}
+}
+
+func (c *Collector) release() {
+	if c.Queue != nil {
+		<-c.Queue
+	}
+}
+
+// start starts iperf3 within a span covering the process start.
+func (c *Collector) start(ctx context.Context, cmd *exec.Cmd) error {
+	_, span := tracer.Start(ctx, "iperf3.process_start", trace.WithAttributes(
+		attribute.String("iperf3.path", c.Iperf3Path),
+	))
+	defer span.End()
+	start := cmd.Start
+	if c.Netns != "" {
+		span.SetAttributes(attribute.String("iperf3.netns", c.Netns))
+		start = func() error { return startInNetns(c.Netns, cmd.Start) }
+	}
+	if err := start(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("iperf3 probe failed: %w", err)
+	}
+	span.SetAttributes(attribute.Int("process.pid", cmd.Process.Pid))
+	return nil
+}
+
+func (c *Collector) runJSON(ctx context.Context) (*Iperf3Results, error) {
+	cmd := exec.CommandContext(ctx, c.Iperf3Path, c.args()...)
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if err := c.start(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	_, runSpan := tracer.Start(ctx, "iperf3.test_run")
+	err := cmd.Wait()
+	if err != nil {
+		runSpan.RecordError(err)
+		runSpan.SetStatus(codes.Error, err.Error())
+	}
+	runSpan.End()
+	if err != nil {
+		return nil, fmt.Errorf("iperf3 probe failed: %w", err)
+	}
+
+	_, parseSpan := tracer.Start(ctx, "iperf3.parse_json", trace.WithAttributes(
+		attribute.Int("iperf3.output_bytes", stdout.Len()),
+	))
+	defer parseSpan.End()
 	results := &Iperf3Results{}
-	if err := json.Unmarshal(out, &results); err != nil {
-		logger.WithFields(log.Fields{
-			"err": err,
-		}).Error("Deserialize iperf3 results failed")
-		c.ErrorCounter.Inc()
-		ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 0)
-		return
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		parseSpan.RecordError(err)
+		parseSpan.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("deserialize iperf3 results failed: %w", err)
 	}
+	return results, nil
+}
 
-	ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, 1)
+// runStream parses the events while the test runs, so the test run span
+// covers parsing as well. Every event is recorded on it.
+func (c *Collector) runStream(ctx context.Context) (*Iperf3Results, error) {
+	cmd := exec.CommandContext(ctx, c.Iperf3Path, c.args()...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.start(ctx, cmd); err != nil {
+		return nil, err
+	}
 
-	if results.Start.TestStart.Reverse > 0 {
-		c.RxCounter.Add(float64(results.End.SummaryReceived.Bytes))
-	} else {
-		c.TxCounter.Add(float64(results.End.SummarySent.Bytes))
+	_, span := tracer.Start(ctx, "iperf3.test_run")
+	defer span.End()
+	results, parseErr := NewStreamParser(func(event *Iperf3Event) {
+		span.AddEvent(event.Event)
+		if c.OnEvent != nil {
+			c.OnEvent(event)
+		}
+	}).Parse(stdout)
+	if parseErr != nil {
+		io.Copy(ioutil.Discard, stdout)
 	}
+	waitErr := cmd.Wait()
 
-	reportMetrics(results, ch)
+	if parseErr != nil {
+		span.RecordError(parseErr)
+		span.SetStatus(codes.Error, parseErr.Error())
+		return nil, fmt.Errorf("iperf3 probe failed: %w", parseErr)
+	}
+	if waitErr != nil {
+		span.RecordError(waitErr)
+		span.SetStatus(codes.Error, waitErr.Error())
+		return nil, fmt.Errorf("iperf3 probe failed: %w", waitErr)
+	}
+	return results, nil
+}
+
+func (c *Collector) streaming() bool {
+	return c.JSONStream || c.OnEvent != nil
+}
+
+func (c *Collector) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"iperf3_path":   c.Iperf3Path,
+		"target":        c.Target,
+		"address":       c.Address,
+		"netns":         c.Netns,
+		"module":        c.Module,
+		"duration":      c.Duration,
+		"omit_duration": c.OmitDuration,
+		"mss":           c.MSS,
+	})
+}
+
+// server returns the host and port of the iperf3 server, leaving the port
+// empty if neither the address nor the collector carry one.
+func (c *Collector) server() (string, string) {
+	// Targets may carry a port, as in Prometheus service discovery.
+	address := c.Target
+	if c.Address != "" {
+		address = c.Address
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return host, port
+	}
+	if c.Port != 0 {
+		return address, strconv.Itoa(c.Port)
+	}
+	return address, ""
+}
+
+func (c *Collector) args() []string {
+	args := []string{"-J"}
+	if !c.UDP {
+		args = append(args, "-M", strconv.Itoa(c.MSS))
+	}
+	switch {
+	case c.Bytes != 0:
+		args = append(args, "-n", strconv.FormatInt(c.Bytes, 10))
+	case c.Blocks != 0:
+		args = append(args, "-k", strconv.Itoa(c.Blocks))
+	default:
+		args = append(args, "-t", strconv.FormatFloat(c.Duration.Seconds(), 'f', 0, 64))
+	}
+	args = append(args, "-O", strconv.FormatFloat(c.OmitDuration.Seconds(), 'f', 0, 64))
+	host, port := c.server()
+	args = append(args, "-c", host)
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	if c.BindAddress != "" {
+		args = append(args, "-B", c.BindAddress)
+	}
+	if c.BindDevice != "" {
+		args = append(args, "--bind-dev", c.BindDevice)
+	}
+	if c.ClientPort != 0 {
+		args = append(args, "--cport", strconv.Itoa(c.ClientPort))
+	}
+	switch c.IPVersion {
+	case 4:
+		args = append(args, "-4")
+	case 6:
+		args = append(args, "-6")
+	}
+	if c.TOS != 0 {
+		args = append(args, "-S", strconv.Itoa(c.TOS))
+	}
+	if c.Window != 0 {
+		args = append(args, "-w", strconv.Itoa(c.Window))
+	}
+	if c.Parallel > 1 {
+		args = append(args, "-P", strconv.Itoa(c.Parallel))
+	}
+	if c.UDP {
+		args = append(args, "-u")
+	}
+	if c.Bitrate != 0 {
+		args = append(args, "-b", strconv.FormatInt(c.Bitrate, 10))
+	}
+	if c.CongestionControl != "" {
+		args = append(args, "-C", c.CongestionControl)
+	}
+	if c.Reverse {
+		args = append(args, "-R")
+	}
+	if c.streaming() {
+		args = append(args, "--json-stream")
+	}
+	return args
 }
 
 func reportMetrics(r *Iperf3Results, ch chan<- prometheus.Metric) {
 	for _, info := range r.Start.Connected {
 		ch <- prometheus.MustNewConstMetric(localPortDesc, prometheus.GaugeValue, float64(info.LocalPort), strconv.Itoa(info.Socket), info.LocalHost)
 		ch <- prometheus.MustNewConstMetric(remotePortDesc, prometheus.GaugeValue, float64(info.RemotePort), strconv.Itoa(info.Socket), info.RemoteHost)
+		ch <- prometheus.MustNewConstMetric(connectionDesc, prometheus.GaugeValue, 1, strconv.Itoa(info.Socket), info.LocalHost, strconv.Itoa(info.LocalPort), info.RemoteHost, strconv.Itoa(info.RemotePort))
 	}
 	ch <- prometheus.MustNewConstMetric(versionDesc, prometheus.GaugeValue, 1, r.Start.Version)
 	ch <- prometheus.MustNewConstMetric(systemInfoDesc, prometheus.GaugeValue, 1, r.Start.SystemInfo)
@@ -279,6 +754,7 @@ func reportMetrics(r *Iperf3Results, ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(r.Start.TestStart.Bytes))
 	ch <- prometheus.MustNewConstMetric(blocksDesc, prometheus.GaugeValue, float64(r.Start.TestStart.Blocks))
 	ch <- prometheus.MustNewConstMetric(reverseDesc, prometheus.GaugeValue, float64(r.Start.TestStart.Reverse))
+	ch <- prometheus.MustNewConstMetric(tosDesc, prometheus.GaugeValue, float64(r.Start.TestStart.TOS))
 
 	for _, interval := range r.Intervals {
 		for _, stream := range interval.Streams {
@@ -311,6 +787,10 @@ func reportMetrics(r *Iperf3Results, ch chan<- prometheus.Metric) {
 	}
 
 	for _, stream := range r.End.Streams {
+		// UDP streams have neither
+		if stream.Sender == nil || stream.Receiver == nil {
+			continue
+		}
 		senderLabels := []string{
 			strconv.Itoa(stream.Sender.Socket),
 			fmt.Sprintf("%f", stream.Sender.Start),
@@ -335,18 +815,47 @@ func reportMetrics(r *Iperf3Results, ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(endStreamsReceiverBytesDesc, prometheus.GaugeValue, float64(stream.Receiver.Bytes), receiverLabels...)
 	}
 
-	ch <- prometheus.MustNewConstMetric(sumSentSecondsDesc, prometheus.GaugeValue, r.End.SummarySent.Seconds)
-	ch <- prometheus.MustNewConstMetric(sumSentBytesDesc, prometheus.GaugeValue, float64(r.End.SummarySent.Bytes))
-	ch <- prometheus.MustNewConstMetric(sumReceivedSecondsDesc, prometheus.GaugeValue, r.End.SummaryReceived.Seconds)
-	ch <- prometheus.MustNewConstMetric(sumReceivedBytesDesc, prometheus.GaugeValue, float64(r.End.SummaryReceived.Bytes))
+	if r.End.SummarySent != nil {
+		ch <- prometheus.MustNewConstMetric(sumSentSecondsDesc, prometheus.GaugeValue, r.End.SummarySent.Seconds)
+		ch <- prometheus.MustNewConstMetric(sumSentBytesDesc, prometheus.GaugeValue, float64(r.End.SummarySent.Bytes))
+	}
+	if r.End.SummaryReceived != nil {
+		ch <- prometheus.MustNewConstMetric(sumReceivedSecondsDesc, prometheus.GaugeValue, r.End.SummaryReceived.Seconds)
+		ch <- prometheus.MustNewConstMetric(sumReceivedBytesDesc, prometheus.GaugeValue, float64(r.End.SummaryReceived.Bytes))
+	}
+	if udp := r.End.Summary; udp != nil {
+		ch <- prometheus.MustNewConstMetric(udpJitterDesc, prometheus.GaugeValue, udp.JitterMs/1000)
+		ch <- prometheus.MustNewConstMetric(udpPacketsDesc, prometheus.GaugeValue, float64(udp.Packets))
+		ch <- prometheus.MustNewConstMetric(udpLostPacketsDesc, prometheus.GaugeValue, float64(udp.LostPackets))
+		ch <- prometheus.MustNewConstMetric(udpLostPercentDesc, prometheus.GaugeValue, udp.LostPercent)
+	}
 
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostTotalDesc, prometheus.GaugeValue, r.End.CpuUsage.HostTotal)
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostUserDesc, prometheus.GaugeValue, r.End.CpuUsage.HostUser)
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostSystemDesc, prometheus.GaugeValue, r.End.CpuUsage.HostSystem)
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteTotalDesc, prometheus.GaugeValue, r.End.CpuUsage.RemoteTotal)
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteUserDesc, prometheus.GaugeValue, r.End.CpuUsage.RemoteUser)
-	ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteSystemDesc, prometheus.GaugeValue, r.End.CpuUsage.RemoteSystem)
+	if cpu := r.End.CpuUsage; cpu != nil {
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostTotalDesc, prometheus.GaugeValue, cpu.HostTotal)
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostUserDesc, prometheus.GaugeValue, cpu.HostUser)
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentHostSystemDesc, prometheus.GaugeValue, cpu.HostSystem)
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteTotalDesc, prometheus.GaugeValue, cpu.RemoteTotal)
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteUserDesc, prometheus.GaugeValue, cpu.RemoteUser)
+		ch <- prometheus.MustNewConstMetric(cpuUtilizationPercentRemoteSystemDesc, prometheus.GaugeValue, cpu.RemoteSystem)
+	}
 
 	ch <- prometheus.MustNewConstMetric(senderTcpCongestionDesc, prometheus.GaugeValue, 1, r.End.SenderTcpCongestion)
 	ch <- prometheus.MustNewConstMetric(receiverTcpCongestionDesc, prometheus.GaugeValue, 1, r.End.ReceiverTcpCongestion)
+
+	reportAnalysis(r, ch)
+	if r.Search != nil {
+		reportSearch(r.Search, ch)
+	}
+	if r.RFC6349 != nil {
+		reportRFC6349(r.RFC6349, ch)
+	}
+	if r.Latency != nil {
+		reportLatency(r.Latency, ch)
+	}
+	if r.MSSSweep != nil {
+		reportMSSSweep(r.MSSSweep, ch)
+	}
+	if r.StreamSweep != nil {
+		reportStreamSweep(r.StreamSweep, ch)
+	}
 }
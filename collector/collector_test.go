@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArgs(t *testing.T) {
+	base := Collector{
+		Target:       "example.com",
+		Duration:     10 * time.Second,
+		OmitDuration: 2 * time.Second,
+		MSS:          1400,
+	}
+	for _, test := range []struct {
+		name   string
+		modify func(c *Collector)
+		want   string
+	}{
+		{"defaults", func(c *Collector) {}, "-J -M 1400 -t 10 -O 2 -c example.com"},
+		{"target port", func(c *Collector) { c.Target = "example.com:5202" }, "-J -M 1400 -t 10 -O 2 -c example.com -p 5202"},
+		{"module port", func(c *Collector) { c.Port = 5203 }, "-J -M 1400 -t 10 -O 2 -c example.com -p 5203"},
+		{"target port over module port", func(c *Collector) {
+			c.Target = "example.com:5202"
+			c.Port = 5203
+		}, "-J -M 1400 -t 10 -O 2 -c example.com -p 5202"},
+		{"resolved address", func(c *Collector) {
+			c.Address = "[2001:db8::1]:5201"
+			c.IPVersion = 6
+		}, "-J -M 1400 -t 10 -O 2 -c 2001:db8::1 -p 5201 -6"},
+		{"ipv4", func(c *Collector) { c.IPVersion = 4 }, "-J -M 1400 -t 10 -O 2 -c example.com -4"},
+		{"bytes", func(c *Collector) { c.Bytes = 1000000 }, "-J -M 1400 -n 1000000 -O 2 -c example.com"},
+		{"blocks", func(c *Collector) { c.Blocks = 100 }, "-J -M 1400 -k 100 -O 2 -c example.com"},
+		{"binding", func(c *Collector) {
+			c.BindAddress = "192.0.2.1"
+			c.BindDevice = "eth1"
+			c.ClientPort = 40000
+		}, "-J -M 1400 -t 10 -O 2 -c example.com -B 192.0.2.1 --bind-dev eth1 --cport 40000"},
+		{"tos, window and streams", func(c *Collector) {
+			c.TOS = 184
+			c.Window = 4194304
+			c.Parallel = 4
+		}, "-J -M 1400 -t 10 -O 2 -c example.com -S 184 -w 4194304 -P 4"},
+		{"single stream", func(c *Collector) { c.Parallel = 1 }, "-J -M 1400 -t 10 -O 2 -c example.com"},
+		{"udp", func(c *Collector) {
+			c.UDP = true
+			c.Bitrate = 100000000
+		}, "-J -t 10 -O 2 -c example.com -u -b 100000000"},
+		{"congestion control and reverse", func(c *Collector) {
+			c.CongestionControl = "bbr"
+			c.Reverse = true
+		}, "-J -M 1400 -t 10 -O 2 -c example.com -C bbr -R"},
+		{"json stream", func(c *Collector) { c.JSONStream = true }, "-J -M 1400 -t 10 -O 2 -c example.com --json-stream"},
+		{"event callback", func(c *Collector) { c.OnEvent = func(*Iperf3Event) {} }, "-J -M 1400 -t 10 -O 2 -c example.com --json-stream"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := base
+			test.modify(&c)
+			if got := strings.Join(c.args(), " "); got != test.want {
+				t.Errorf("args() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// reportCollector reports fixed results with the descriptions of Collector,
+// so a pedantic registry checks that every metric reported is described.
+type reportCollector struct {
+	Collector
+	results *Iperf3Results
+}
+
+func (c *reportCollector) Collect(ch chan<- prometheus.Metric) {
+	reportMetrics(c.results, ch)
+}
+
+func TestReportMetrics(t *testing.T) {
+	tcp, err := NewStreamParser(nil).Parse(strings.NewReader(testStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp := &Iperf3Results{}
+	if err := json.Unmarshal([]byte(`{"start":{"test_start":{"protocol":"UDP"}},"end":{"sum":{"bits_per_second":4e8,"jitter_ms":2,"packets":1000,"lost_packets":5,"lost_percent":0.5}}}`), udp); err != nil {
+		t.Fatal(err)
+	}
+	udp.Search = &SearchResult{Bitrate: 4e8, Steps: []*SearchStep{
+		{Bitrate: 9e8, BitsPerSecond: 5e8, LostPercent: 44},
+		{Bitrate: 4e8, BitsPerSecond: 4e8, LostPercent: 0.5, Passed: true},
+	}}
+
+	for _, test := range []struct {
+		name    string
+		results *Iperf3Results
+		metrics []string
+		want    string
+	}{
+		{"tcp", tcp, []string{"iperf3_tcp_mss_bytes", "iperf3_intervals_streams_round_trip_time_seconds", "iperf3_sum_sent_bytes", "iperf3_sum_received_bytes", "iperf3_udp_lost_percent"}, `
+# HELP iperf3_intervals_streams_round_trip_time_seconds Round trip time in interval
+# TYPE iperf3_intervals_streams_round_trip_time_seconds gauge
+iperf3_intervals_streams_round_trip_time_seconds{end="1.000000",omitted="false",sender="true",socket="5",start="0.000000"} 0.0015
+iperf3_intervals_streams_round_trip_time_seconds{end="2.000000",omitted="false",sender="true",socket="5",start="1.000000"} 0.0025
+# HELP iperf3_sum_received_bytes Total received bytes
+# TYPE iperf3_sum_received_bytes gauge
+iperf3_sum_received_bytes 1.87e+08
+# HELP iperf3_sum_sent_bytes Total bytes sent
+# TYPE iperf3_sum_sent_bytes gauge
+iperf3_sum_sent_bytes 1.875e+08
+# HELP iperf3_tcp_mss_bytes TCPP maximum segment size
+# TYPE iperf3_tcp_mss_bytes gauge
+iperf3_tcp_mss_bytes 1448
+`},
+		{"udp search", udp, []string{"iperf3_protocol_info", "iperf3_udp_lost_percent", "iperf3_udp_jitter_seconds", "iperf3_udp_search_bitrate_bps", "iperf3_udp_search_step_passed_bool", "iperf3_sum_sent_bytes"}, `
+# HELP iperf3_protocol_info Test protocol
+# TYPE iperf3_protocol_info gauge
+iperf3_protocol_info{protocol="UDP"} 1
+# HELP iperf3_udp_jitter_seconds UDP packet delay variation
+# TYPE iperf3_udp_jitter_seconds gauge
+iperf3_udp_jitter_seconds 0.002
+# HELP iperf3_udp_lost_percent Percentage of UDP packets lost
+# TYPE iperf3_udp_lost_percent gauge
+iperf3_udp_lost_percent 0.5
+# HELP iperf3_udp_search_bitrate_bps Highest bitrate at which the loss stayed within the threshold, 0 if there is none
+# TYPE iperf3_udp_search_bitrate_bps gauge
+iperf3_udp_search_bitrate_bps 4e+08
+# HELP iperf3_udp_search_step_passed_bool 1 if the loss of a search step stayed within the threshold
+# TYPE iperf3_udp_search_step_passed_bool gauge
+iperf3_udp_search_step_passed_bool{bitrate="400000000",step="1"} 1
+iperf3_udp_search_step_passed_bool{bitrate="900000000",step="0"} 0
+`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			registry := prometheus.NewPedanticRegistry()
+			if err := registry.Register(&reportCollector{results: test.results}); err != nil {
+				t.Fatal(err)
+			}
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(test.want), test.metrics...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -4,6 +4,17 @@ type Iperf3Results struct {
 	Start     *Iperf3Start      `json:"start"`
 	Intervals []*Iperf3Interval `json:"intervals"`
 	End       *Iperf3End        `json:"end"`
+
+	// Search is set by the exporter on the results of a bitrate search.
+	Search *SearchResult `json:"search,omitempty"`
+	// RFC6349 is set by the exporter if the probe evaluates RFC 6349 metrics.
+	RFC6349 *RFC6349Result `json:"rfc6349,omitempty"`
+	// Latency is set by the exporter if the probe measured the idle latency.
+	Latency *LatencyResult `json:"latency,omitempty"`
+	// MSSSweep is set by the exporter on the results of an MSS sweep.
+	MSSSweep *MSSSweepResult `json:"mss_sweep,omitempty"`
+	// StreamSweep is set by the exporter on the results of a stream sweep.
+	StreamSweep *StreamSweepResult `json:"stream_sweep,omitempty"`
 }
 
 type Iperf3Start struct {
@@ -73,6 +84,7 @@ type Iperf3End struct {
 	Streams               []*Iperf3EndStream     `json:"streams"`
 	SummarySent           *Iperf3SummarySent     `json:"sum_sent"`
 	SummaryReceived       *Iperf3SummaryReceived `json:"sum_received"`
+	Summary               *Iperf3SummaryUDP      `json:"sum"`
 	CpuUsage              *Iperf3CpuUsage        `json:"cpu_utilization_percent"`
 	SenderTcpCongestion   string                 `json:"sender_tcp_congestion"`
 	ReceiverTcpCongestion string                 `json:"receiver_tcp_congestion"`
@@ -127,6 +139,19 @@ type Iperf3SummaryReceived struct {
 	Sender        bool    `json:"sender"`
 }
 
+type Iperf3SummaryUDP struct {
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	Seconds       float64 `json:"seconds"`
+	Bytes         int     `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	JitterMs      float64 `json:"jitter_ms"`
+	LostPackets   int     `json:"lost_packets"`
+	Packets       int     `json:"packets"`
+	LostPercent   float64 `json:"lost_percent"`
+	Sender        bool    `json:"sender"`
+}
+
 type Iperf3CpuUsage struct {
 	HostTotal    float64 `json:"host_total"`
 	HostUser     float64 `json:"host_user"`
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
+	"net"
+	"strconv"
+	"time"
+)
+
+// idleLatencyProbes is the number of connection setups timed to measure the
+// idle latency.
+const idleLatencyProbes = 3
+
+// LatencyResult compares the round trip time of the idle path, measured
+// before the test, with the one under load. Loaded and Increase are omitted
+// if iperf3 reported no round trip times, e.g. for UDP tests.
+type LatencyResult struct {
+	Idle     float64  `json:"idle"`
+	Loaded   *float64 `json:"loaded,omitempty"`
+	Increase *float64 `json:"increase,omitempty"`
+}
+
+var (
+	idleLatencyDesc     *prometheus.Desc
+	loadedLatencyDesc   *prometheus.Desc
+	latencyIncreaseDesc *prometheus.Desc
+)
+
+func init() {
+	idleLatencyDesc = prometheus.NewDesc("iperf3_idle_latency_seconds", "Shortest TCP connection setup to the server before the test", nil, nil)
+	loadedLatencyDesc = prometheus.NewDesc("iperf3_loaded_latency_seconds", "Average round trip time during the test", nil, nil)
+	latencyIncreaseDesc = prometheus.NewDesc("iperf3_latency_under_load_increase_seconds", "Increase of the round trip time under load over the idle latency", nil, nil)
+}
+
+func describeLatency(ch chan<- *prometheus.Desc) {
+	ch <- idleLatencyDesc
+	ch <- loadedLatencyDesc
+	ch <- latencyIncreaseDesc
+}
+
+func reportLatency(l *LatencyResult, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(idleLatencyDesc, prometheus.GaugeValue, l.Idle)
+	if l.Loaded != nil {
+		ch <- prometheus.MustNewConstMetric(loadedLatencyDesc, prometheus.GaugeValue, *l.Loaded)
+		ch <- prometheus.MustNewConstMetric(latencyIncreaseDesc, prometheus.GaugeValue, *l.Increase)
+	}
+}
+
+func newLatencyResult(idle time.Duration, r *Iperf3Results) *LatencyResult {
+	l := &LatencyResult{Idle: idle.Seconds()}
+	if loaded, ok := averageRoundTripTime(r); ok {
+		increase := loaded - l.Idle
+		l.Loaded = &loaded
+		l.Increase = &increase
+	}
+	return l
+}
+
+// idleLatency returns the shortest of several TCP connection setups to
+// IdleLatencyPort of the server, from the local address, interface and
+// network namespace iperf3 uses. The iperf3 port is left alone, as iperf3
+// servers take every connection to it for a test. The client port is left
+// to the kernel, as iperf3 binds it right after.
+func (c *Collector) idleLatency(ctx context.Context) (time.Duration, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.idle_latency")
+	defer span.End()
+
+	idle, err := c.dialServer(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	return idle, nil
+}
+
+func (c *Collector) dialServer(ctx context.Context) (time.Duration, error) {
+	if c.IdleLatencyPort == 0 {
+		return 0, fmt.Errorf("no idle latency port")
+	}
+	version := c.IPVersion
+	if version == 0 && c.BindAddress != "" {
+		version = 6
+		if net.ParseIP(c.BindAddress).To4() != nil {
+			version = 4
+		}
+	}
+	network := "tcp"
+	if version != 0 {
+		network = "tcp" + strconv.Itoa(version)
+	}
+	// Resolve first, so only the connection setup is timed.
+	ip, err := c.serverIP(ctx, version)
+	if err != nil {
+		return 0, err
+	}
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(c.IdleLatencyPort))
+
+	dialer := &net.Dialer{}
+	if c.BindAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(c.BindAddress)}
+	}
+	if c.BindDevice != "" {
+		dialer.Control = bindToDevice(c.BindDevice)
+	}
+
+	var shortest time.Duration
+	for i := 0; i < idleLatencyProbes; i++ {
+		var elapsed time.Duration
+		dial := func() error {
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return err
+			}
+			elapsed = time.Since(start)
+			return conn.Close()
+		}
+		if c.Netns != "" {
+			err = startInNetns(c.Netns, dial)
+		} else {
+			err = dial()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || elapsed < shortest {
+			shortest = elapsed
+		}
+	}
+	return shortest, nil
+}
+
+// serverIP returns the address of the server iperf3 connects to: Address
+// if set, or else the first address of the target of IP version, if not 0.
+func (c *Collector) serverIP(ctx context.Context, version int) (net.IP, error) {
+	host, _ := c.server()
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	ips, err := c.resolver().LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if version == 0 || (ip.To4() != nil) == (version == 4) {
+			return ip, nil
+		}
+	}
+	if version != 0 {
+		return nil, fmt.Errorf("no IPv%d address of %s", version, host)
+	}
+	return nil, fmt.Errorf("no address of %s", host)
+}
+
+// resolver returns the resolver for the name of the server, querying
+// DNSServer if set. Within a network namespace it queries the name servers
+// from there.
+func (c *Collector) resolver() *net.Resolver {
+	if c.Netns == "" && c.DNSServer == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if c.DNSServer != "" {
+				address = c.DNSServer
+			}
+			var conn net.Conn
+			dial := func() error {
+				var err error
+				var d net.Dialer
+				conn, err = d.DialContext(ctx, network, address)
+				return err
+			}
+			var err error
+			if c.Netns != "" {
+				err = startInNetns(c.Netns, dial)
+			} else {
+				err = dial()
+			}
+			return conn, err
+		},
+	}
+}
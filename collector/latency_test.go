@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"context"
+	"github.com/fluepke/iperf3-exporter/internal/dnstest"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testListener accepts and closes connections, counting them.
+func testListener(t *testing.T) (int, *int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, &accepted
+}
+
+func TestIdleLatency(t *testing.T) {
+	dnsServer := dnstest.NewServer(t, dnstest.Zone{
+		A: map[string][]string{"iperf3.test.": {"127.0.0.1"}},
+	})
+	iperf3Port, iperf3Accepted := testListener(t)
+	latencyPort, latencyAccepted := testListener(t)
+
+	c := &Collector{
+		Target:          net.JoinHostPort("iperf3.test", strconv.Itoa(iperf3Port)),
+		IPVersion:       4,
+		IdleLatencyPort: latencyPort,
+		DNSServer:       dnsServer.Addr,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	idle, err := c.idleLatency(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idle <= 0 || idle > time.Second {
+		t.Errorf("idle latency = %s, want a short positive duration", idle)
+	}
+	if dnsServer.Queries() == 0 {
+		t.Error("the DNS server was not queried")
+	}
+
+	// The listener counts a connection once it accepted it, possibly after
+	// the client closed it.
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(latencyAccepted) < idleLatencyProbes && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(latencyAccepted); got != idleLatencyProbes {
+		t.Errorf("got %d connections to the idle latency port, want %d", got, idleLatencyProbes)
+	}
+	if got := atomic.LoadInt32(iperf3Accepted); got != 0 {
+		t.Errorf("got %d connections to the iperf3 port, want none", got)
+	}
+
+	c.IdleLatencyPort = 0
+	if _, err := c.idleLatency(ctx); err == nil {
+		t.Error("idle latency without a port succeeded, want error")
+	}
+}
+
+func TestServerIP(t *testing.T) {
+	dnsServer := dnstest.NewServer(t, dnstest.Zone{
+		A:    map[string][]string{"iperf3.test.": {"192.0.2.1"}, "ip4.test.": {"192.0.2.2"}},
+		AAAA: map[string][]string{"iperf3.test.": {"2001:db8::1"}},
+	})
+	for _, test := range []struct {
+		name    string
+		c       Collector
+		version int
+		want    string
+	}{
+		{"ip4", Collector{Target: "iperf3.test"}, 4, "192.0.2.1"},
+		{"ip6", Collector{Target: "iperf3.test:5201"}, 6, "2001:db8::1"},
+		{"address", Collector{Target: "iperf3.test", Address: "[2001:db8::2]:5201"}, 6, "2001:db8::2"},
+		{"no ip6 address", Collector{Target: "ip4.test"}, 6, ""},
+	} {
+		test.c.DNSServer = dnsServer.Addr
+		ip, err := test.c.serverIP(context.Background(), test.version)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %s, want error", test.name, ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if ip.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, ip, test.want)
+		}
+	}
+}
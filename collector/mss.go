@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"strconv"
+)
+
+// MSSSweep runs a test per MSS to find path MTU problems. A test reaches
+// full throughput if it achieves FullThroughput times the throughput of the
+// best test.
+type MSSSweep struct {
+	MSS            []int
+	FullThroughput float64
+}
+
+// MSSSweepResult is the outcome of an MSS sweep.
+type MSSSweepResult struct {
+	// LargestFullThroughputMSS is 0 if no test succeeded.
+	LargestFullThroughputMSS int `json:"largest_full_throughput_mss"`
+	// BlackHole is set if a larger MSS failed or fell short of full
+	// throughput while a smaller one reached it, as when large segments are
+	// dropped without ICMP feedback.
+	BlackHole bool `json:"black_hole"`
+	// Fragmentation is set if segments of some MSS did not fit the path MTU
+	// iperf3 reported.
+	Fragmentation bool            `json:"fragmentation"`
+	Steps         []*MSSSweepStep `json:"steps"`
+}
+
+// MSSSweepStep is the test at one MSS of a sweep.
+type MSSSweepStep struct {
+	MSS           int     `json:"mss"`
+	Success       bool    `json:"success"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"`
+	// PathMTU is the smallest path MTU reported in the intervals, 0 if none.
+	PathMTU        int  `json:"pmtu"`
+	ExceedsPathMTU bool `json:"exceeds_pmtu"`
+	FullThroughput bool `json:"full_throughput"`
+}
+
+var (
+	mssSweepLargestDesc       *prometheus.Desc
+	mssSweepBlackHoleDesc     *prometheus.Desc
+	mssSweepFragmentationDesc *prometheus.Desc
+
+	mssSweepStepSuccessDesc        *prometheus.Desc
+	mssSweepStepBitsPerSecondDesc  *prometheus.Desc
+	mssSweepStepRetransmitsDesc    *prometheus.Desc
+	mssSweepStepPathMTUDesc        *prometheus.Desc
+	mssSweepStepExceedsPathMTUDesc *prometheus.Desc
+)
+
+func init() {
+	mssSweepLargestDesc = prometheus.NewDesc("iperf3_mss_sweep_largest_full_throughput_mss_bytes", "Largest MSS that achieved full throughput, 0 if no test succeeded", nil, nil)
+	mssSweepBlackHoleDesc = prometheus.NewDesc("iperf3_mss_sweep_black_hole_bool", "1 if a larger MSS failed or fell short of full throughput while a smaller one reached it", nil, nil)
+	mssSweepFragmentationDesc = prometheus.NewDesc("iperf3_mss_sweep_fragmentation_bool", "1 if segments of some MSS did not fit the reported path MTU", nil, nil)
+
+	stepLabels := []string{"mss"}
+	mssSweepStepSuccessDesc = prometheus.NewDesc("iperf3_mss_sweep_step_success_bool", "1 if the test at an MSS succeeded", stepLabels, nil)
+	mssSweepStepBitsPerSecondDesc = prometheus.NewDesc("iperf3_mss_sweep_step_bits_per_second", "Throughput received at an MSS", stepLabels, nil)
+	mssSweepStepRetransmitsDesc = prometheus.NewDesc("iperf3_mss_sweep_step_retransmits", "Retransmits at an MSS", stepLabels, nil)
+	mssSweepStepPathMTUDesc = prometheus.NewDesc("iperf3_mss_sweep_step_path_mtu_bytes", "Smallest path MTU reported at an MSS", stepLabels, nil)
+	mssSweepStepExceedsPathMTUDesc = prometheus.NewDesc("iperf3_mss_sweep_step_exceeds_path_mtu_bool", "1 if segments of an MSS plus headers did not fit the path MTU", stepLabels, nil)
+}
+
+func describeMSSSweep(ch chan<- *prometheus.Desc) {
+	ch <- mssSweepLargestDesc
+	ch <- mssSweepBlackHoleDesc
+	ch <- mssSweepFragmentationDesc
+
+	ch <- mssSweepStepSuccessDesc
+	ch <- mssSweepStepBitsPerSecondDesc
+	ch <- mssSweepStepRetransmitsDesc
+	ch <- mssSweepStepPathMTUDesc
+	ch <- mssSweepStepExceedsPathMTUDesc
+}
+
+func reportMSSSweep(s *MSSSweepResult, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(mssSweepLargestDesc, prometheus.GaugeValue, float64(s.LargestFullThroughputMSS))
+	ch <- prometheus.MustNewConstMetric(mssSweepBlackHoleDesc, prometheus.GaugeValue, boolToFloat(s.BlackHole))
+	ch <- prometheus.MustNewConstMetric(mssSweepFragmentationDesc, prometheus.GaugeValue, boolToFloat(s.Fragmentation))
+	for _, step := range s.Steps {
+		mss := strconv.Itoa(step.MSS)
+		ch <- prometheus.MustNewConstMetric(mssSweepStepSuccessDesc, prometheus.GaugeValue, boolToFloat(step.Success), mss)
+		if !step.Success {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(mssSweepStepBitsPerSecondDesc, prometheus.GaugeValue, step.BitsPerSecond, mss)
+		ch <- prometheus.MustNewConstMetric(mssSweepStepRetransmitsDesc, prometheus.GaugeValue, float64(step.Retransmits), mss)
+		if step.PathMTU > 0 {
+			ch <- prometheus.MustNewConstMetric(mssSweepStepPathMTUDesc, prometheus.GaugeValue, float64(step.PathMTU), mss)
+			ch <- prometheus.MustNewConstMetric(mssSweepStepExceedsPathMTUDesc, prometheus.GaugeValue, boolToFloat(step.ExceedsPathMTU), mss)
+		}
+	}
+}
+
+// sweepMSS runs a test per MSS, in ascending order. Failing tests are
+// recorded rather than aborting the sweep, as they may be caused by a black
+// hole. It returns the record of the test at the largest MSS reaching full
+// throughput, with the result of the sweep.
+func (c *Collector) sweepMSS(ctx context.Context) (*Record, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.mss_sweep", trace.WithAttributes(
+		attribute.IntSlice("iperf3.mss_sweep.mss", c.MSSSweep.MSS),
+	))
+	defer span.End()
+
+	result := &MSSSweepResult{}
+	all := make([]*Record, len(c.MSSSweep.MSS))
+	var lastErr error
+	succeeded := 0
+	for i, mss := range c.MSSSweep.MSS {
+		test := *c
+		test.MSSSweep = nil
+		test.MSS = mss
+		step := &MSSSweepStep{MSS: mss}
+		result.Steps = append(result.Steps, step)
+
+		record, err := test.runTest(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		all[i] = record
+		results := record.Results
+		succeeded++
+		step.Success = true
+		if received := results.End.SummaryReceived; received != nil {
+			step.BitsPerSecond = received.BitsPerSecond
+		}
+		if sent := results.End.SummarySent; sent != nil {
+			step.Retransmits = sent.Retransmits
+		}
+		step.PathMTU = smallestPathMTU(results)
+		step.ExceedsPathMTU = step.PathMTU > 0 && mss+headerOverhead(results) > step.PathMTU
+	}
+	if succeeded == 0 {
+		span.RecordError(lastErr)
+		span.SetStatus(codes.Error, lastErr.Error())
+		return nil, lastErr
+	}
+
+	found := c.MSSSweep.evaluate(result)
+	span.SetAttributes(
+		attribute.Int("iperf3.mss_sweep.largest_full_throughput_mss", result.LargestFullThroughputMSS),
+		attribute.Bool("iperf3.mss_sweep.black_hole", result.BlackHole),
+	)
+
+	if found < 0 {
+		// Only tests without throughput succeeded.
+		for i := range all {
+			if all[i] != nil {
+				found = i
+			}
+		}
+	}
+	record := *all[found]
+	results := *record.Results
+	results.MSSSweep = result
+	record.Results = &results
+	return &record, nil
+}
+
+// evaluate marks the steps reaching full throughput and sets the largest
+// MSS doing so, a black hole and fragmentation on the result. It returns
+// the index of the step at the largest MSS reaching full throughput, or -1
+// if none did.
+func (s *MSSSweep) evaluate(result *MSSSweepResult) int {
+	var best float64
+	for _, step := range result.Steps {
+		if step.Success && step.BitsPerSecond > best {
+			best = step.BitsPerSecond
+		}
+	}
+	found := -1
+	for i, step := range result.Steps {
+		step.FullThroughput = step.Success && best > 0 && step.BitsPerSecond >= best*s.FullThroughput
+		if step.FullThroughput {
+			result.LargestFullThroughputMSS = step.MSS
+			found = i
+		} else if found >= 0 {
+			result.BlackHole = true
+		}
+		if step.ExceedsPathMTU {
+			result.Fragmentation = true
+		}
+	}
+	return found
+}
+
+// smallestPathMTU returns the smallest path MTU reported in the intervals,
+// or 0 if there is none.
+func smallestPathMTU(r *Iperf3Results) int {
+	pmtu := 0
+	for _, interval := range r.Intervals {
+		for _, stream := range interval.Streams {
+			if stream.PathMTU > 0 && (pmtu == 0 || stream.PathMTU < pmtu) {
+				pmtu = stream.PathMTU
+			}
+		}
+	}
+	return pmtu
+}
+
+// headerOverhead returns the size of the IP and TCP headers of a segment,
+// without options.
+func headerOverhead(r *Iperf3Results) int {
+	for _, connected := range r.Start.Connected {
+		if ip := net.ParseIP(connected.RemoteHost); ip != nil && ip.To4() == nil {
+			return 60
+		}
+	}
+	return 40
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"testing"
+)
+
+func TestMSSSweepEvaluate(t *testing.T) {
+	sweep := &MSSSweep{MSS: []int{536, 1220, 1460}, FullThroughput: 0.9}
+	for _, test := range []struct {
+		name          string
+		steps         []*MSSSweepStep
+		found         int
+		largest       int
+		blackHole     bool
+		fragmentation bool
+	}{
+		{"all full throughput", []*MSSSweepStep{
+			{MSS: 536, Success: true, BitsPerSecond: 950},
+			{MSS: 1220, Success: true, BitsPerSecond: 990},
+			{MSS: 1460, Success: true, BitsPerSecond: 1000},
+		}, 2, 1460, false, false},
+		{"largest fails", []*MSSSweepStep{
+			{MSS: 536, Success: true, BitsPerSecond: 950},
+			{MSS: 1220, Success: true, BitsPerSecond: 1000},
+			{MSS: 1460},
+		}, 1, 1220, true, false},
+		{"largest falls short", []*MSSSweepStep{
+			{MSS: 536, Success: true, BitsPerSecond: 950},
+			{MSS: 1220, Success: true, BitsPerSecond: 1000},
+			{MSS: 1460, Success: true, BitsPerSecond: 100},
+		}, 1, 1220, true, false},
+		{"smallest falls short", []*MSSSweepStep{
+			{MSS: 536, Success: true, BitsPerSecond: 500},
+			{MSS: 1220, Success: true, BitsPerSecond: 950},
+			{MSS: 1460, Success: true, BitsPerSecond: 1000},
+		}, 2, 1460, false, false},
+		{"fragmented", []*MSSSweepStep{
+			{MSS: 536, Success: true, BitsPerSecond: 1000},
+			{MSS: 1220, Success: true, BitsPerSecond: 1000},
+			{MSS: 1460, Success: true, BitsPerSecond: 1000, PathMTU: 1400, ExceedsPathMTU: true},
+		}, 2, 1460, false, true},
+		{"no throughput", []*MSSSweepStep{
+			{MSS: 536, Success: true},
+			{MSS: 1220},
+			{MSS: 1460},
+		}, -1, 0, false, false},
+	} {
+		result := &MSSSweepResult{Steps: test.steps}
+		found := sweep.evaluate(result)
+		if found != test.found {
+			t.Errorf("%s: found step %d, want %d", test.name, found, test.found)
+		}
+		if result.LargestFullThroughputMSS != test.largest {
+			t.Errorf("%s: largest full throughput MSS = %d, want %d", test.name, result.LargestFullThroughputMSS, test.largest)
+		}
+		if result.BlackHole != test.blackHole {
+			t.Errorf("%s: black hole = %t, want %t", test.name, result.BlackHole, test.blackHole)
+		}
+		if result.Fragmentation != test.fragmentation {
+			t.Errorf("%s: fragmentation = %t, want %t", test.name, result.Fragmentation, test.fragmentation)
+		}
+	}
+}
+
+func TestHeaderOverhead(t *testing.T) {
+	for _, test := range []struct {
+		remote string
+		want   int
+	}{
+		{"192.0.2.1", 40},
+		{"2001:db8::1", 60},
+	} {
+		r := &Iperf3Results{Start: &Iperf3Start{Connected: []*Iperf3Connected{{RemoteHost: test.remote}}}}
+		if got := headerOverhead(r); got != test.want {
+			t.Errorf("headerOverhead(%s) = %d, want %d", test.remote, got, test.want)
+		}
+	}
+}
+
+func TestSmallestPathMTU(t *testing.T) {
+	r := &Iperf3Results{Intervals: []*Iperf3Interval{
+		{Streams: []*Iperf3IntervalStream{{PathMTU: 1500}, {PathMTU: 0}}},
+		{Streams: []*Iperf3IntervalStream{{PathMTU: 1280}, {PathMTU: 1500}}},
+	}}
+	if got := smallestPathMTU(r); got != 1280 {
+		t.Errorf("smallestPathMTU() = %d, want 1280", got)
+	}
+	if got := smallestPathMTU(&Iperf3Results{}); got != 0 {
+		t.Errorf("smallestPathMTU() without intervals = %d, want 0", got)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"runtime"
+)
+
+// startInNetns calls start on an OS thread switched to the network namespace
+// at path, so processes and sockets created by it are in the namespace.
+func startInNetns(path string, start func() error) error {
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening network namespace: %w", err)
+	}
+	defer target.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked, so the runtime terminates it when
+		// the goroutine exits instead of reusing it in the namespace.
+		runtime.LockOSThread()
+		if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			errCh <- fmt.Errorf("entering network namespace %s: %w", path, err)
+			return
+		}
+		errCh <- start()
+	}()
+	return <-errCh
+}
@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNetns creates a network namespace connected to the current one by a
+// veth pair, with 10.199.0.1/30 on the outer and 10.199.0.2/30 on the inner
+// end. It returns the path of the namespace and the name of the inner end.
+func testNetns(t *testing.T) (string, string) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating network namespaces requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("creating network namespaces requires ip")
+	}
+	name := "iperf3-exporter-test-" + strconv.Itoa(os.Getpid())
+	outer, inner := fmt.Sprintf("ve%do", os.Getpid()), fmt.Sprintf("ve%di", os.Getpid())
+	ip := func(args ...string) {
+		if output, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Fatalf("ip %s: %s: %s", strings.Join(args, " "), err, output)
+		}
+	}
+	ip("netns", "add", name)
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", outer).Run()
+		exec.Command("ip", "netns", "del", name).Run()
+	})
+	ip("link", "add", outer, "type", "veth", "peer", "name", inner)
+	ip("link", "set", inner, "netns", name)
+	ip("addr", "add", "10.199.0.1/30", "dev", outer)
+	ip("link", "set", outer, "up")
+	ip("-n", name, "addr", "add", "10.199.0.2/30", "dev", inner)
+	ip("-n", name, "link", "set", inner, "up")
+	ip("-n", name, "link", "set", "lo", "up")
+	return "/var/run/netns/" + name, inner
+}
+
+func TestStartInNetns(t *testing.T) {
+	path, inner := testNetns(t)
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	before, err := os.Readlink("/proc/thread-self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("cat", "/proc/self/net/dev")
+	cmd.Stdout = &stdout
+	if err := startInNetns(path, cmd.Start); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), inner+":") {
+		t.Errorf("process started outside of the namespace, its interfaces are\n%s", stdout.String())
+	}
+
+	if after, _ := os.Readlink("/proc/thread-self/ns/net"); after != before {
+		t.Errorf("calling thread switched from %s to %s", before, after)
+	}
+	if err := startInNetns("/nonexistent/netns", func() error { return nil }); err == nil {
+		t.Error("startInNetns succeeded with a missing namespace, want error")
+	}
+}
+
+func TestIdleLatencyInNetns(t *testing.T) {
+	path, _ := testNetns(t)
+
+	// The listener on the loopback interface of the namespace is only
+	// reachable from within the namespace.
+	var listener net.Listener
+	if err := startInNetns(path, func() error {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	c := &Collector{Target: "127.0.0.1", IdleLatencyPort: listener.Addr().(*net.TCPAddr).Port, Netns: path}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	idle, err := c.idleLatency(ctx)
+	if err != nil {
+		t.Fatalf("idle latency within the namespace: %s", err)
+	}
+	if idle <= 0 || idle > time.Second {
+		t.Errorf("idle latency = %s, want a short positive duration", idle)
+	}
+
+	// The outer end of the veth pair is reachable through it.
+	outer, err := net.Listen("tcp", "10.199.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+	go func() {
+		conn, err := outer.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	c = &Collector{Target: "10.199.0.1", IdleLatencyPort: outer.Addr().(*net.TCPAddr).Port, Netns: path, BindAddress: "10.199.0.2"}
+	if _, err := c.idleLatency(ctx); err != nil {
+		t.Errorf("idle latency across the veth pair: %s", err)
+	}
+}
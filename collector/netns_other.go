@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	"errors"
+)
+
+func startInNetns(path string, start func() error) error {
+	return errors.New("network namespaces are only supported on Linux")
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// RFC6349 evaluates TCP tests against the baseline round trip time and
+// bottleneck bandwidth of the path, as in the TCP throughput testing
+// methodology of RFC 6349.
+type RFC6349 struct {
+	BaselineRTT time.Duration
+	// BottleneckBandwidth in bits per second.
+	BottleneckBandwidth int64
+}
+
+// The maximum achievable TCP throughput of RFC 6349 section 3.3.1 leaves out
+// the IPv4 and TCP headers without options, the difference of MTU and MSS, of
+// every segment. Without an MSS reported by iperf3, that of a 1500 byte MTU
+// is assumed.
+const (
+	tcpIPHeaderBytes = 40
+	defaultMSS       = 1500 - tcpIPHeaderBytes
+)
+
+// RFC6349Result holds the metrics of RFC 6349 section 4. TCPEfficiency is
+// omitted if iperf3 did not report the MSS, BufferDelay if there are no round
+// trip times.
+type RFC6349Result struct {
+	IdealTransferTime  float64  `json:"ideal_transfer_time"`
+	ActualTransferTime float64  `json:"actual_transfer_time"`
+	TransferTimeRatio  float64  `json:"transfer_time_ratio"`
+	TCPEfficiency      *float64 `json:"tcp_efficiency,omitempty"`
+	AverageRTT         *float64 `json:"average_rtt,omitempty"`
+	BufferDelay        *float64 `json:"buffer_delay,omitempty"`
+}
+
+var (
+	rfc6349IdealTransferTimeDesc  *prometheus.Desc
+	rfc6349ActualTransferTimeDesc *prometheus.Desc
+	rfc6349TransferTimeRatioDesc  *prometheus.Desc
+	rfc6349TCPEfficiencyDesc      *prometheus.Desc
+	rfc6349AverageRTTDesc         *prometheus.Desc
+	rfc6349BufferDelayDesc        *prometheus.Desc
+)
+
+func init() {
+	rfc6349IdealTransferTimeDesc = prometheus.NewDesc("iperf3_rfc6349_ideal_transfer_time_seconds", "Time the bytes received take at the maximum achievable TCP throughput of the bottleneck bandwidth", nil, nil)
+	rfc6349ActualTransferTimeDesc = prometheus.NewDesc("iperf3_rfc6349_actual_transfer_time_seconds", "Time the receiver took to receive the bytes", nil, nil)
+	rfc6349TransferTimeRatioDesc = prometheus.NewDesc("iperf3_rfc6349_transfer_time_ratio", "RFC 6349 TCP Transfer Time Ratio of actual to ideal transfer time", nil, nil)
+	rfc6349TCPEfficiencyDesc = prometheus.NewDesc("iperf3_rfc6349_tcp_efficiency_percent", "RFC 6349 TCP Efficiency, the percentage of transmitted bytes not retransmitted", nil, nil)
+	rfc6349AverageRTTDesc = prometheus.NewDesc("iperf3_rfc6349_average_round_trip_time_seconds", "Average round trip time during the transfer", nil, nil)
+	rfc6349BufferDelayDesc = prometheus.NewDesc("iperf3_rfc6349_buffer_delay_percent", "RFC 6349 Buffer Delay Percentage, the increase of the average over the baseline round trip time", nil, nil)
+}
+
+func describeRFC6349(ch chan<- *prometheus.Desc) {
+	ch <- rfc6349IdealTransferTimeDesc
+	ch <- rfc6349ActualTransferTimeDesc
+	ch <- rfc6349TransferTimeRatioDesc
+	ch <- rfc6349TCPEfficiencyDesc
+	ch <- rfc6349AverageRTTDesc
+	ch <- rfc6349BufferDelayDesc
+}
+
+func reportRFC6349(r *RFC6349Result, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(rfc6349IdealTransferTimeDesc, prometheus.GaugeValue, r.IdealTransferTime)
+	ch <- prometheus.MustNewConstMetric(rfc6349ActualTransferTimeDesc, prometheus.GaugeValue, r.ActualTransferTime)
+	ch <- prometheus.MustNewConstMetric(rfc6349TransferTimeRatioDesc, prometheus.GaugeValue, r.TransferTimeRatio)
+	if r.TCPEfficiency != nil {
+		ch <- prometheus.MustNewConstMetric(rfc6349TCPEfficiencyDesc, prometheus.GaugeValue, *r.TCPEfficiency)
+	}
+	if r.AverageRTT != nil {
+		ch <- prometheus.MustNewConstMetric(rfc6349AverageRTTDesc, prometheus.GaugeValue, *r.AverageRTT)
+		ch <- prometheus.MustNewConstMetric(rfc6349BufferDelayDesc, prometheus.GaugeValue, *r.BufferDelay)
+	}
+}
+
+// Evaluate computes the RFC 6349 metrics of TCP results, or returns nil if
+// the results lack the summaries.
+func (b *RFC6349) Evaluate(r *Iperf3Results) *RFC6349Result {
+	if r.End == nil || r.End.SummarySent == nil || r.End.SummaryReceived == nil || r.End.SummaryReceived.Seconds <= 0 {
+		return nil
+	}
+	received := r.End.SummaryReceived
+	mss := r.Start.TcpMSS
+	if mss <= 0 {
+		mss = defaultMSS
+	}
+	result := &RFC6349Result{
+		IdealTransferTime:  float64(received.Bytes) * 8 / b.maxThroughput(mss),
+		ActualTransferTime: received.Seconds,
+	}
+	if result.IdealTransferTime > 0 {
+		result.TransferTimeRatio = result.ActualTransferTime / result.IdealTransferTime
+	}
+
+	// iperf3 counts retransmitted segments, each of at most one MSS.
+	if sent := r.End.SummarySent; r.Start.TcpMSS > 0 && sent.Bytes > 0 {
+		retransmitted := float64(sent.Retransmits * r.Start.TcpMSS)
+		efficiency := float64(sent.Bytes) / (float64(sent.Bytes) + retransmitted) * 100
+		result.TCPEfficiency = &efficiency
+	}
+
+	if rtt, ok := averageRoundTripTime(r); ok {
+		baseline := b.BaselineRTT.Seconds()
+		delay := (rtt - baseline) / baseline * 100
+		result.AverageRTT = &rtt
+		result.BufferDelay = &delay
+	}
+	return result
+}
+
+// maxThroughput returns the maximum achievable TCP throughput in bits per
+// second at the bottleneck bandwidth with segments of mss bytes.
+func (b *RFC6349) maxThroughput(mss int) float64 {
+	return float64(b.BottleneckBandwidth) * float64(mss) / float64(mss+tcpIPHeaderBytes)
+}
+
+// averageRoundTripTime returns the average round trip time in seconds over
+// the intervals not omitted, or over the streams if the intervals carry
+// none.
+func averageRoundTripTime(r *Iperf3Results) (float64, bool) {
+	var sum float64
+	var n int
+	for _, interval := range r.Intervals {
+		for _, stream := range interval.Streams {
+			if stream.Omitted || !stream.Sender || stream.RoundTripTime <= 0 {
+				continue
+			}
+			sum += stream.RoundTripTime
+			n++
+		}
+	}
+	if n == 0 {
+		return meanRoundTripTime(r)
+	}
+	return sum / float64(n) / 1000000, true
+}
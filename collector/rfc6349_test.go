@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRFC6349Evaluate(t *testing.T) {
+	b := &RFC6349{BaselineRTT: 10 * time.Millisecond, BottleneckBandwidth: 100000000}
+	for _, test := range []struct {
+		name       string
+		mss        int
+		bytes      int
+		ideal      float64
+		ratio      float64
+		efficiency float64
+	}{
+		// At 100 Mbit/s, 1460 byte segments carry 97.3 Mbit/s of data.
+		{"1460 byte segments", 1460, 14600000, 1.2, 2, 14600000.0 / 14746000 * 100},
+		{"mss not reported", 0, 14600000, 1.2, 2, 0},
+		{"536 byte segments", 536, 13400000, 1.152, 2.4 / 1.152, 13400000.0 / 13453600 * 100},
+	} {
+		result := b.Evaluate(&Iperf3Results{
+			Start: &Iperf3Start{TcpMSS: test.mss},
+			Intervals: []*Iperf3Interval{{Streams: []*Iperf3IntervalStream{
+				{Sender: true, RoundTripTime: 15000},
+			}}},
+			End: &Iperf3End{
+				SummarySent:     &Iperf3SummarySent{Bytes: test.bytes, Retransmits: 100},
+				SummaryReceived: &Iperf3SummaryReceived{Bytes: test.bytes, Seconds: 2.4},
+			},
+		})
+		if math.Abs(result.IdealTransferTime-test.ideal) > 1e-9 {
+			t.Errorf("%s: ideal transfer time = %g, want %g", test.name, result.IdealTransferTime, test.ideal)
+		}
+		if math.Abs(result.TransferTimeRatio-test.ratio) > 1e-9 {
+			t.Errorf("%s: transfer time ratio = %g, want %g", test.name, result.TransferTimeRatio, test.ratio)
+		}
+		if test.efficiency == 0 && result.TCPEfficiency != nil {
+			t.Errorf("%s: TCP efficiency = %g, want none", test.name, *result.TCPEfficiency)
+		}
+		if test.efficiency != 0 && (result.TCPEfficiency == nil || math.Abs(*result.TCPEfficiency-test.efficiency) > 1e-9) {
+			t.Errorf("%s: TCP efficiency is not %g", test.name, test.efficiency)
+		}
+		if result.BufferDelay == nil || math.Abs(*result.BufferDelay-50) > 1e-9 {
+			t.Errorf("%s: buffer delay is not 50%%", test.name)
+		}
+	}
+}
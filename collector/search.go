@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+)
+
+// Bitrate search methods.
+const (
+	SearchBinary = "binary"
+	SearchRamp   = "ramp"
+)
+
+// Search finds the highest UDP bitrate at which at most LossThreshold percent
+// of the packets are lost, in the spirit of the RFC 2544 throughput test.
+// Binary search narrows the range between MinBitrate and MaxBitrate down to
+// Precision; ramp raises the bitrate from MinBitrate by Step until the loss
+// exceeds the threshold or MaxBitrate is reached.
+type Search struct {
+	Method        string
+	MinBitrate    int64
+	MaxBitrate    int64
+	Step          int64
+	Precision     int64
+	LossThreshold float64
+}
+
+// SearchResult is the outcome of a bitrate search.
+type SearchResult struct {
+	// Bitrate is the highest bitrate that passed, or 0 if none did.
+	Bitrate int64         `json:"bitrate"`
+	Steps   []*SearchStep `json:"steps"`
+}
+
+// SearchStep is the test at one bitrate of a search.
+type SearchStep struct {
+	Bitrate       int64   `json:"bitrate"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	JitterMs      float64 `json:"jitter_ms"`
+	LostPercent   float64 `json:"lost_percent"`
+	Passed        bool    `json:"passed"`
+}
+
+var (
+	searchBitrateDesc *prometheus.Desc
+	searchStepsDesc   *prometheus.Desc
+
+	searchStepBitsPerSecondDesc *prometheus.Desc
+	searchStepJitterDesc        *prometheus.Desc
+	searchStepLostPercentDesc   *prometheus.Desc
+	searchStepPassedDesc        *prometheus.Desc
+)
+
+func init() {
+	searchBitrateDesc = prometheus.NewDesc("iperf3_udp_search_bitrate_bps", "Highest bitrate at which the loss stayed within the threshold, 0 if there is none", nil, nil)
+	searchStepsDesc = prometheus.NewDesc("iperf3_udp_search_steps_count", "Tests run by the bitrate search", nil, nil)
+
+	stepLabels := []string{"step", "bitrate"}
+	searchStepBitsPerSecondDesc = prometheus.NewDesc("iperf3_udp_search_step_bits_per_second", "Throughput of a search step", stepLabels, nil)
+	searchStepJitterDesc = prometheus.NewDesc("iperf3_udp_search_step_jitter_seconds", "Packet delay variation of a search step", stepLabels, nil)
+	searchStepLostPercentDesc = prometheus.NewDesc("iperf3_udp_search_step_lost_percent", "Percentage of packets lost in a search step", stepLabels, nil)
+	searchStepPassedDesc = prometheus.NewDesc("iperf3_udp_search_step_passed_bool", "1 if the loss of a search step stayed within the threshold", stepLabels, nil)
+}
+
+func describeSearch(ch chan<- *prometheus.Desc) {
+	ch <- searchBitrateDesc
+	ch <- searchStepsDesc
+
+	ch <- searchStepBitsPerSecondDesc
+	ch <- searchStepJitterDesc
+	ch <- searchStepLostPercentDesc
+	ch <- searchStepPassedDesc
+}
+
+func reportSearch(s *SearchResult, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(searchBitrateDesc, prometheus.GaugeValue, float64(s.Bitrate))
+	ch <- prometheus.MustNewConstMetric(searchStepsDesc, prometheus.GaugeValue, float64(len(s.Steps)))
+	for i, step := range s.Steps {
+		labels := []string{strconv.Itoa(i), strconv.FormatInt(step.Bitrate, 10)}
+		ch <- prometheus.MustNewConstMetric(searchStepBitsPerSecondDesc, prometheus.GaugeValue, step.BitsPerSecond, labels...)
+		ch <- prometheus.MustNewConstMetric(searchStepJitterDesc, prometheus.GaugeValue, step.JitterMs/1000, labels...)
+		ch <- prometheus.MustNewConstMetric(searchStepLostPercentDesc, prometheus.GaugeValue, step.LostPercent, labels...)
+		ch <- prometheus.MustNewConstMetric(searchStepPassedDesc, prometheus.GaugeValue, boolToFloat(step.Passed), labels...)
+	}
+}
+
+// search runs the tests of the search one after another. It returns the
+// record of the test at the bitrate found, or of the last test if none
+// passed, with the result of the search. A failing test aborts the search.
+func (c *Collector) search(ctx context.Context) (*Record, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.search", trace.WithAttributes(
+		attribute.String("iperf3.search.method", c.Search.Method),
+		attribute.Int64("iperf3.search.min_bitrate", c.Search.MinBitrate),
+		attribute.Int64("iperf3.search.max_bitrate", c.Search.MaxBitrate),
+	))
+	defer span.End()
+
+	result := &SearchResult{}
+	var found, last *Record
+	test := func(bitrate int64) (bool, error) {
+		step := *c
+		step.Search = nil
+		step.Bitrate = bitrate
+		record, err := step.runTest(ctx)
+		if err != nil {
+			return false, err
+		}
+		last = record
+		s := &SearchStep{Bitrate: bitrate}
+		if udp := record.Results.End.Summary; udp != nil {
+			s.BitsPerSecond = udp.BitsPerSecond
+			s.JitterMs = udp.JitterMs
+			s.LostPercent = udp.LostPercent
+			s.Passed = udp.LostPercent <= c.Search.LossThreshold
+		}
+		result.Steps = append(result.Steps, s)
+		if s.Passed {
+			found = record
+			result.Bitrate = bitrate
+		}
+		return s.Passed, nil
+	}
+
+	var err error
+	if c.Search.Method == SearchRamp {
+		err = c.Search.ramp(test)
+	} else {
+		err = c.Search.binary(test)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("iperf3.search.bitrate", result.Bitrate))
+
+	record := *last
+	if found != nil {
+		record = *found
+	}
+	results := *record.Results
+	results.Search = result
+	record.Results = &results
+	return &record, nil
+}
+
+func (s *Search) ramp(test func(int64) (bool, error)) error {
+	for bitrate := s.MinBitrate; bitrate <= s.MaxBitrate; bitrate += s.Step {
+		passed, err := test(bitrate)
+		if err != nil || !passed {
+			return err
+		}
+	}
+	return nil
+}
+
+// binary tests the maximum bitrate first, as a path that sustains it needs
+// no further steps, then the minimum and then halves the range in between.
+func (s *Search) binary(test func(int64) (bool, error)) error {
+	passed, err := test(s.MaxBitrate)
+	if err != nil || passed {
+		return err
+	}
+	passed, err = test(s.MinBitrate)
+	if err != nil || !passed {
+		return err
+	}
+	precision := s.Precision
+	if precision < 1 {
+		precision = 1
+	}
+	low, high := s.MinBitrate, s.MaxBitrate
+	for high-low > precision {
+		bitrate := low + (high-low)/2
+		passed, err := test(bitrate)
+		if err != nil {
+			return err
+		}
+		if passed {
+			low = bitrate
+		} else {
+			high = bitrate
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// capacityTest returns a search test passing at bitrates up to capacity,
+// and failing with an error at failAt, recording the bitrates it was called with.
+func capacityTest(capacity, failAt int64, tested *[]int64) func(int64) (bool, error) {
+	return func(bitrate int64) (bool, error) {
+		*tested = append(*tested, bitrate)
+		if bitrate == failAt {
+			return false, errors.New("test failed")
+		}
+		return bitrate <= capacity, nil
+	}
+}
+
+func TestSearchBinary(t *testing.T) {
+	search := Search{Method: SearchBinary, MinBitrate: 100, MaxBitrate: 900, Precision: 100}
+	for _, test := range []struct {
+		name     string
+		capacity int64
+		failAt   int64
+		tested   []int64
+		err      bool
+	}{
+		{"max passes", 1000, 0, []int64{900}, false},
+		{"min fails", 50, 0, []int64{900, 100}, false},
+		{"bisects", 450, 0, []int64{900, 100, 500, 300, 400}, false},
+		{"bisects up", 880, 0, []int64{900, 100, 500, 700, 800}, false},
+		{"failing test aborts", 450, 500, []int64{900, 100, 500}, true},
+	} {
+		var tested []int64
+		err := search.binary(capacityTest(test.capacity, test.failAt, &tested))
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v, want error %t", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(tested, test.tested) {
+			t.Errorf("%s: tested %v, want %v", test.name, tested, test.tested)
+		}
+	}
+}
+
+func TestSearchRamp(t *testing.T) {
+	search := Search{Method: SearchRamp, MinBitrate: 100, MaxBitrate: 500, Step: 100}
+	for _, test := range []struct {
+		name     string
+		capacity int64
+		failAt   int64
+		tested   []int64
+		err      bool
+	}{
+		{"all pass", 1000, 0, []int64{100, 200, 300, 400, 500}, false},
+		{"stops at first loss", 250, 0, []int64{100, 200, 300}, false},
+		{"min fails", 50, 0, []int64{100}, false},
+		{"failing test aborts", 1000, 200, []int64{100, 200}, true},
+	} {
+		var tested []int64
+		err := search.ramp(capacityTest(test.capacity, test.failAt, &tested))
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v, want error %t", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(tested, test.tested) {
+			t.Errorf("%s: tested %v, want %v", test.name, tested, test.tested)
+		}
+	}
+}
+
+type recordingSink struct {
+	records []*Record
+}
+
+func (s *recordingSink) Write(record *Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+// testIperf3 writes a stub iperf3 reporting UDP loss above capacity bits per
+// second and returns its path.
+func testIperf3(t *testing.T, capacity int64) string {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the stub iperf3 requires sh")
+	}
+	path := filepath.Join(t.TempDir(), "iperf3")
+	script := fmt.Sprintf(`#!/bin/sh
+while [ $# -gt 0 ]; do
+	if [ "$1" = -b ]; then bitrate=$2; fi
+	shift
+done
+lost=0
+if [ "$bitrate" -gt %d ]; then lost=5; fi
+echo "{\"start\":{\"test_start\":{\"protocol\":\"UDP\"}},\"end\":{\"sum\":{\"bits_per_second\":$bitrate,\"lost_percent\":$lost}}}"
+`, capacity)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunSearchWritesOneRecord(t *testing.T) {
+	sink, accounted := &recordingSink{}, &recordingSink{}
+	c := &Collector{
+		Iperf3Path:   testIperf3(t, 450),
+		Target:       "192.0.2.1",
+		Module:       "udp-capacity",
+		Timeout:      5 * time.Second,
+		UDP:          true,
+		Search:       &Search{Method: SearchBinary, MinBitrate: 100, MaxBitrate: 900, Precision: 100, LossThreshold: 1},
+		Sinks:        []Sink{sink},
+		Account:      accounted.Write,
+		ErrorCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		RxCounter:    prometheus.NewCounter(prometheus.CounterOpts{Name: "rx"}),
+		TxCounter:    prometheus.NewCounter(prometheus.CounterOpts{Name: "tx"}),
+		ProbeCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "probes"}),
+	}
+	results, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Search == nil || results.Search.Bitrate != 400 || len(results.Search.Steps) != 5 {
+		t.Fatalf("search = %+v, want bitrate 400 after 5 steps", results.Search)
+	}
+	if got := results.End.Summary.BitsPerSecond; got != 400 {
+		t.Errorf("results are those of the test at %g bit/s, want 400", got)
+	}
+
+	if len(accounted.records) != 5 {
+		t.Errorf("accounted %d records, want one per step", len(accounted.records))
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("got %d records, want one for the whole search", len(sink.records))
+	}
+	record := sink.records[0]
+	if record.Results.Search != results.Search || record.Target != c.Target || record.Module != c.Module {
+		t.Errorf("record = %+v, want the results of the search", record)
+	}
+	if !record.Start.Before(record.End) {
+		t.Errorf("record starts at %s and ends at %s", record.Start, record.End)
+	}
+}
@@ -0,0 +1,20 @@
+package collector
+
+import (
+	"time"
+)
+
+// Record is a completed probe together with the context it was run in.
+type Record struct {
+	Target  string            `json:"target"`
+	Module  string            `json:"module"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Results *Iperf3Results    `json:"results"`
+}
+
+// Sink receives the record of every successful probe.
+type Sink interface {
+	Write(*Record) error
+}
@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+// bindToDevice returns a dialer control function binding sockets to device.
+func bindToDevice(device string) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		var err error
+		if controlErr := conn.Control(func(fd uintptr) {
+			err = unix.BindToDevice(int(fd), device)
+		}); controlErr != nil {
+			return controlErr
+		}
+		return err
+	}
+}
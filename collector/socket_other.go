@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToDevice(device string) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		return errors.New("binding to an interface is only supported on Linux")
+	}
+}
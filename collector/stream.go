@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Iperf3Event is a single line of the output of iperf3 --json-stream.
+type Iperf3Event struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// StreamParser incrementally assembles Iperf3Results from the events
+// emitted by iperf3 --json-stream.
+type StreamParser struct {
+	OnEvent func(*Iperf3Event)
+
+	results *Iperf3Results
+	done    bool
+}
+
+func NewStreamParser(onEvent func(*Iperf3Event)) *StreamParser {
+	return &StreamParser{
+		OnEvent: onEvent,
+		results: &Iperf3Results{},
+	}
+}
+
+// Feed parses a single event line. It returns an error if the line is
+// malformed or iperf3 reported an error.
+func (p *StreamParser) Feed(line []byte) error {
+	event := &Iperf3Event{}
+	if err := json.Unmarshal(line, event); err != nil {
+		return err
+	}
+
+	switch event.Event {
+	case "start":
+		if err := json.Unmarshal(event.Data, &p.results.Start); err != nil {
+			return fmt.Errorf("start event: %w", err)
+		}
+	case "interval":
+		interval := &Iperf3Interval{}
+		if err := json.Unmarshal(event.Data, interval); err != nil {
+			return fmt.Errorf("interval event: %w", err)
+		}
+		p.results.Intervals = append(p.results.Intervals, interval)
+	case "end":
+		if err := json.Unmarshal(event.Data, &p.results.End); err != nil {
+			return fmt.Errorf("end event: %w", err)
+		}
+		p.done = true
+	case "error":
+		var message string
+		if err := json.Unmarshal(event.Data, &message); err != nil {
+			message = string(event.Data)
+		}
+		return errors.New(message)
+	}
+
+	if p.OnEvent != nil {
+		p.OnEvent(event)
+	}
+	return nil
+}
+
+// Parse feeds every line of r to the parser and returns the assembled results.
+func (p *StreamParser) Parse(r io.Reader) (*Iperf3Results, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := p.Feed(scanner.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p.Results()
+}
+
+// Results returns the assembled results once the end event has been seen.
+func (p *StreamParser) Results() (*Iperf3Results, error) {
+	if !p.done || p.results.Start == nil {
+		return nil, errors.New("incomplete iperf3 event stream")
+	}
+	return p.results, nil
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+)
+
+const testStream = `{"event":"start","data":{"connected":[{"socket":5,"local_host":"10.0.0.2","local_port":40000,"remote_host":"10.0.0.1","remote_port":5201}],"version":"iperf 3.17","tcp_mss":1448,"test_start":{"protocol":"TCP","num_streams":1,"omit":0,"duration":2}}}
+{"event":"interval","data":{"streams":[{"socket":5,"start":0,"end":1,"seconds":1,"bytes":125000000,"bits_per_second":1e9,"retransmits":1,"rtt":1500,"sender":true}],"sum":{"start":0,"end":1,"seconds":1,"bytes":125000000,"bits_per_second":1e9,"retransmits":1,"sender":true}}}
+
+{"event":"interval","data":{"streams":[{"socket":5,"start":1,"end":2,"seconds":1,"bytes":62500000,"bits_per_second":5e8,"retransmits":0,"rtt":2500,"sender":true}],"sum":{"start":1,"end":2,"seconds":1,"bytes":62500000,"bits_per_second":5e8,"retransmits":0,"sender":true}}}
+{"event":"end","data":{"sum_sent":{"start":0,"end":2,"seconds":2,"bytes":187500000,"bits_per_second":7.5e8,"retransmits":1,"sender":true},"sum_received":{"start":0,"end":2,"seconds":2,"bytes":187000000,"bits_per_second":7.48e8,"sender":false}}}
+`
+
+func TestStreamParserParse(t *testing.T) {
+	var events []string
+	parser := NewStreamParser(func(event *Iperf3Event) {
+		events = append(events, event.Event)
+	})
+	results, err := parser.Parse(strings.NewReader(testStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Join(events, ","), "start,interval,interval,end"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+	if results.Start.Version != "iperf 3.17" || results.Start.TcpMSS != 1448 {
+		t.Errorf("start = %+v, want version iperf 3.17 and MSS 1448", results.Start)
+	}
+	if len(results.Intervals) != 2 {
+		t.Fatalf("got %d intervals, want 2", len(results.Intervals))
+	}
+	if got := results.Intervals[1].Streams[0].RoundTripTime; got != 2500 {
+		t.Errorf("RTT of second interval = %g, want 2500", got)
+	}
+	if got := results.End.SummarySent.Bytes; got != 187500000 {
+		t.Errorf("bytes sent = %d, want 187500000", got)
+	}
+	if got := results.End.SummaryReceived.BitsPerSecond; got != 7.48e8 {
+		t.Errorf("bits per second received = %g, want 7.48e8", got)
+	}
+}
+
+func TestStreamParserIncomplete(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		stream string
+	}{
+		{"empty", ""},
+		{"no end", strings.SplitAfter(testStream, "\n")[0]},
+		{"no start", `{"event":"end","data":{}}`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			parser := NewStreamParser(nil)
+			if _, err := parser.Parse(strings.NewReader(test.stream)); err == nil {
+				t.Error("Parse succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestStreamParserError(t *testing.T) {
+	var events []string
+	parser := NewStreamParser(func(event *Iperf3Event) {
+		events = append(events, event.Event)
+	})
+	stream := strings.SplitAfter(testStream, "\n")[0] + `{"event":"error","data":"unable to receive results"}` + "\n"
+	_, err := parser.Parse(strings.NewReader(stream))
+	if err == nil || err.Error() != "unable to receive results" {
+		t.Errorf("Parse error = %v, want the error reported by iperf3", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("events = %v, want only the start event relayed", events)
+	}
+}
+
+func TestStreamParserMalformed(t *testing.T) {
+	for _, line := range []string{
+		`{"event":"start"`,
+		`{"event":"interval","data":{"streams":"none"}}`,
+		`{"event":"end","data":[]}`,
+	} {
+		if err := NewStreamParser(nil).Feed([]byte(line)); err == nil {
+			t.Errorf("Feed(%s) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+)
+
+// StreamSweepResult is the outcome of a sweep over the number of parallel
+// streams.
+type StreamSweepResult struct {
+	// BestStreams is the stream count with the highest throughput.
+	BestStreams int                `json:"best_streams"`
+	Steps       []*StreamSweepStep `json:"steps"`
+}
+
+// StreamSweepStep is the test at one stream count of a sweep.
+type StreamSweepStep struct {
+	Streams       int     `json:"streams"`
+	Success       bool    `json:"success"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	// ScalingEfficiency is the throughput per stream relative to the one of
+	// the smallest stream count that succeeded: 1 if throughput grows
+	// linearly with the streams, 1/streams if it stays flat.
+	ScalingEfficiency float64 `json:"scaling_efficiency"`
+}
+
+var (
+	streamSweepBestStreamsDesc *prometheus.Desc
+
+	streamSweepStepSuccessDesc           *prometheus.Desc
+	streamSweepStepBitsPerSecondDesc     *prometheus.Desc
+	streamSweepStepScalingEfficiencyDesc *prometheus.Desc
+)
+
+func init() {
+	streamSweepBestStreamsDesc = prometheus.NewDesc("iperf3_stream_sweep_best_streams", "Number of parallel streams with the highest throughput", nil, nil)
+
+	stepLabels := []string{"streams"}
+	streamSweepStepSuccessDesc = prometheus.NewDesc("iperf3_stream_sweep_step_success_bool", "1 if the test with a number of streams succeeded", stepLabels, nil)
+	streamSweepStepBitsPerSecondDesc = prometheus.NewDesc("iperf3_stream_sweep_step_bits_per_second", "Throughput received with a number of streams", stepLabels, nil)
+	streamSweepStepScalingEfficiencyDesc = prometheus.NewDesc("iperf3_stream_sweep_step_scaling_efficiency_ratio", "Throughput per stream relative to the one with the fewest streams", stepLabels, nil)
+}
+
+func describeStreamSweep(ch chan<- *prometheus.Desc) {
+	ch <- streamSweepBestStreamsDesc
+
+	ch <- streamSweepStepSuccessDesc
+	ch <- streamSweepStepBitsPerSecondDesc
+	ch <- streamSweepStepScalingEfficiencyDesc
+}
+
+func reportStreamSweep(s *StreamSweepResult, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(streamSweepBestStreamsDesc, prometheus.GaugeValue, float64(s.BestStreams))
+	for _, step := range s.Steps {
+		streams := strconv.Itoa(step.Streams)
+		ch <- prometheus.MustNewConstMetric(streamSweepStepSuccessDesc, prometheus.GaugeValue, boolToFloat(step.Success), streams)
+		if !step.Success {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(streamSweepStepBitsPerSecondDesc, prometheus.GaugeValue, step.BitsPerSecond, streams)
+		ch <- prometheus.MustNewConstMetric(streamSweepStepScalingEfficiencyDesc, prometheus.GaugeValue, step.ScalingEfficiency, streams)
+	}
+}
+
+// sweepStreams runs a test per stream count, in ascending order. Like
+// sweepMSS it records failing tests and fails only if all tests do. It
+// returns the record of the test with the highest throughput, with the
+// result of the sweep.
+func (c *Collector) sweepStreams(ctx context.Context) (*Record, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.stream_sweep", trace.WithAttributes(
+		attribute.IntSlice("iperf3.stream_sweep.streams", c.StreamSweep),
+	))
+	defer span.End()
+
+	result := &StreamSweepResult{}
+	all := make([]*Record, len(c.StreamSweep))
+	var lastErr error
+	for i, streams := range c.StreamSweep {
+		test := *c
+		test.StreamSweep = nil
+		test.Parallel = streams
+		step := &StreamSweepStep{Streams: streams}
+		result.Steps = append(result.Steps, step)
+
+		record, err := test.runTest(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		all[i] = record
+		step.Success = true
+		step.BitsPerSecond = receivedBitsPerSecond(record.Results)
+	}
+	best := result.evaluate()
+	if best < 0 {
+		span.RecordError(lastErr)
+		span.SetStatus(codes.Error, lastErr.Error())
+		return nil, lastErr
+	}
+	span.SetAttributes(attribute.Int("iperf3.stream_sweep.best_streams", result.BestStreams))
+
+	record := *all[best]
+	results := *record.Results
+	results.StreamSweep = result
+	record.Results = &results
+	return &record, nil
+}
+
+// evaluate sets the scaling efficiency of the steps that succeeded, relative
+// to the first of them, and the stream count with the highest throughput. It
+// returns the index of its step, or -1 if no step succeeded.
+func (r *StreamSweepResult) evaluate() int {
+	best := -1
+	var base float64
+	for i, step := range r.Steps {
+		if !step.Success {
+			continue
+		}
+		perStream := step.BitsPerSecond / float64(step.Streams)
+		if best < 0 {
+			base = perStream
+		}
+		if base > 0 {
+			step.ScalingEfficiency = perStream / base
+		}
+		if best < 0 || step.BitsPerSecond > r.Steps[best].BitsPerSecond {
+			best = i
+			r.BestStreams = step.Streams
+		}
+	}
+	return best
+}
+
+// receivedBitsPerSecond returns the throughput received in a TCP or UDP test.
+func receivedBitsPerSecond(r *Iperf3Results) float64 {
+	if received := r.End.SummaryReceived; received != nil {
+		return received.BitsPerSecond
+	}
+	if udp := r.End.Summary; udp != nil {
+		return udp.BitsPerSecond
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStreamSweepEvaluate(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		steps      []*StreamSweepStep
+		best       int
+		streams    int
+		efficiency []float64
+	}{
+		{"linear", []*StreamSweepStep{
+			{Streams: 1, Success: true, BitsPerSecond: 100},
+			{Streams: 2, Success: true, BitsPerSecond: 200},
+			{Streams: 4, Success: true, BitsPerSecond: 400},
+		}, 2, 4, []float64{1, 1, 1}},
+		{"flat", []*StreamSweepStep{
+			{Streams: 1, Success: true, BitsPerSecond: 400},
+			{Streams: 2, Success: true, BitsPerSecond: 400},
+			{Streams: 4, Success: true, BitsPerSecond: 400},
+		}, 0, 1, []float64{1, 0.5, 0.25}},
+		{"relative to the first success", []*StreamSweepStep{
+			{Streams: 1},
+			{Streams: 2, Success: true, BitsPerSecond: 200},
+			{Streams: 4, Success: true, BitsPerSecond: 300},
+		}, 2, 4, []float64{0, 1, 0.75}},
+		{"best before failure", []*StreamSweepStep{
+			{Streams: 1, Success: true, BitsPerSecond: 100},
+			{Streams: 8, Success: true, BitsPerSecond: 500},
+			{Streams: 16},
+		}, 1, 8, []float64{1, 0.625, 0}},
+		{"no throughput", []*StreamSweepStep{
+			{Streams: 1, Success: true},
+			{Streams: 2, Success: true},
+		}, 0, 1, []float64{0, 0}},
+		{"all failed", []*StreamSweepStep{
+			{Streams: 1},
+			{Streams: 2},
+		}, -1, 0, []float64{0, 0}},
+	} {
+		result := &StreamSweepResult{Steps: test.steps}
+		best := result.evaluate()
+		if best != test.best || result.BestStreams != test.streams {
+			t.Errorf("%s: best step %d with %d streams, want %d with %d", test.name, best, result.BestStreams, test.best, test.streams)
+		}
+		var efficiency []float64
+		for _, step := range result.Steps {
+			efficiency = append(efficiency, step.ScalingEfficiency)
+		}
+		if !reflect.DeepEqual(efficiency, test.efficiency) {
+			t.Errorf("%s: scaling efficiency %v, want %v", test.name, efficiency, test.efficiency)
+		}
+	}
+}
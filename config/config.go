@@ -0,0 +1,759 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultModule = "default"
+
+const (
+	ResolveFirst = "first"
+	ResolveAll   = "all"
+
+	IPProtocol4      = "ip4"
+	IPProtocol6      = "ip6"
+	IPProtocolPrefer = "prefer"
+)
+
+const (
+	BudgetActionBlock     = "block"
+	BudgetActionDowngrade = "downgrade"
+)
+
+const (
+	SearchBinary = "binary"
+	SearchRamp   = "ramp"
+)
+
+type Config struct {
+	Modules   map[string]*Module `yaml:"modules"`
+	Schedules []*Schedule        `yaml:"schedules"`
+	FileSD    []*FileSD          `yaml:"file_sd_configs"`
+	Budgets   []*Budget          `yaml:"budgets"`
+}
+
+type Module struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	Duration     time.Duration `yaml:"duration"`
+	OmitDuration time.Duration `yaml:"omit_duration"`
+	MSS          int           `yaml:"mss"`
+	Window       int           `yaml:"window"`
+	Reverse      bool          `yaml:"reverse"`
+	JSONStream   bool          `yaml:"json_stream"`
+	// Bytes or Blocks transfer a fixed amount instead of testing for
+	// Duration. They require MinBitrate, in bits per second, as the timeout
+	// is extended by the time the transfer takes at that rate.
+	Bytes      int64 `yaml:"bytes"`
+	Blocks     int   `yaml:"blocks"`
+	MinBitrate int64 `yaml:"min_bitrate"`
+	// Resolve makes the exporter resolve targets itself and probe the first
+	// or all of their addresses, instead of leaving it to iperf3. With
+	// resolve all, at most MaxAddresses addresses of each probed IP family
+	// are tested.
+	Resolve      string `yaml:"resolve"`
+	SRV          bool   `yaml:"srv"`
+	MaxAddresses int    `yaml:"max_addresses"`
+	// IPProtocol forces IPv4 or IPv6, or prefers PreferredIPProtocol if the
+	// target has an address of that family. DualStack probes both families
+	// one after another.
+	IPProtocol          string `yaml:"ip_protocol"`
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+	DualStack           bool   `yaml:"dual_stack"`
+	// Port is the server port for targets without one. BindAddress and
+	// Interface select the local address and interface or VRF to test from,
+	// ClientPort the local port.
+	Port        int    `yaml:"port"`
+	BindAddress string `yaml:"bind_address"`
+	Interface   string `yaml:"interface"`
+	ClientPort  int    `yaml:"client_port"`
+	// Netns runs iperf3 in the named network namespace.
+	Netns string `yaml:"netns"`
+	// DSCP marks the test traffic with a DSCP class, TOS sets the whole type
+	// of service byte instead. QoSClasses tests every listed DSCP class one
+	// after another.
+	DSCP       string   `yaml:"dscp"`
+	TOS        int      `yaml:"tos"`
+	QoSClasses []string `yaml:"qos_classes"`
+	// UDP tests with UDP at Bitrate in bits per second. UDPSearch searches
+	// for the highest bitrate with acceptable loss instead.
+	UDP       bool       `yaml:"udp"`
+	Bitrate   int64      `yaml:"bitrate"`
+	UDPSearch *UDPSearch `yaml:"udp_search"`
+	// MSSSweep runs a test per MSS to find path MTU problems.
+	MSSSweep *MSSSweep `yaml:"mss_sweep"`
+	// StreamSweep runs a test per number of parallel streams, in ascending
+	// order, to tell per-flow from aggregate limits.
+	StreamSweep []int `yaml:"stream_sweep"`
+	// IdleLatency measures the latency before the test to compare it with
+	// the one under load, by timing connection setups to IdleLatencyPort of
+	// the server, e.g. an echo or SSH port.
+	IdleLatency     bool `yaml:"idle_latency"`
+	IdleLatencyPort int  `yaml:"idle_latency_port"`
+	// RFC6349 evaluates TCP tests against the baseline of the path.
+	RFC6349 *RFC6349 `yaml:"rfc6349"`
+	// CongestionControl selects the TCP congestion control algorithm, e.g.
+	// bbr or cubic. CongestionControls compares the listed algorithms one
+	// after another.
+	CongestionControl  string   `yaml:"congestion_control"`
+	CongestionControls []string `yaml:"congestion_controls"`
+}
+
+// NetnsPath returns the path of the network namespace of the module, as
+// created by ip netns, or Netns itself if it is a path.
+func (m *Module) NetnsPath() string {
+	if m.Netns == "" || filepath.IsAbs(m.Netns) {
+		return m.Netns
+	}
+	return filepath.Join("/var/run/netns", m.Netns)
+}
+
+// DefaultBlockSize and DefaultUDPBlockSize are the block sizes of iperf3 TCP
+// and UDP tests.
+const (
+	DefaultBlockSize    = 128 * 1024
+	DefaultUDPBlockSize = 1460
+)
+
+// TransferBytes returns the bytes a fixed-size transfer moves, or 0 if the
+// module tests for a duration.
+func (m *Module) TransferBytes() int64 {
+	if m.Bytes != 0 {
+		return m.Bytes
+	}
+	if m.UDP {
+		return int64(m.Blocks) * DefaultUDPBlockSize
+	}
+	return int64(m.Blocks) * DefaultBlockSize
+}
+
+// ProbeTimeout returns the timeout of a probe, extended for fixed-size
+// transfers by the time the transfer takes at MinBitrate.
+func (m *Module) ProbeTimeout() time.Duration {
+	bytes := m.TransferBytes()
+	if bytes == 0 || m.MinBitrate == 0 {
+		return m.Timeout
+	}
+	transfer := time.Duration(float64(bytes*8) / float64(m.MinBitrate) * float64(time.Second))
+	return m.Timeout + m.OmitDuration + transfer
+}
+
+// maxProbeTests bounds the tests a probe runs one after another.
+const maxProbeTests = 64
+
+// DefaultMaxAddresses is the number of addresses of each family probed with
+// resolve all, unless max_addresses is set.
+const DefaultMaxAddresses = 4
+
+// Tests returns the most tests a probe of the module runs, one after
+// another: one per probed address, IP family, QoS class and congestion
+// control algorithm, times the steps of a bitrate search, MSS sweep or
+// stream sweep.
+func (m *Module) Tests() int {
+	tests := 1
+	if m.UDPSearch != nil {
+		tests = m.UDPSearch.Steps()
+	}
+	if m.MSSSweep != nil {
+		tests = len(m.MSSSweep.MSS)
+	}
+	if n := len(m.StreamSweep); n > 0 {
+		tests = n
+	}
+	if m.Resolve == ResolveAll && m.MaxAddresses > 0 {
+		tests *= m.MaxAddresses
+	}
+	if m.DualStack {
+		tests *= 2
+	}
+	if n := len(m.QoSClasses); n > 0 {
+		tests *= n
+	}
+	if n := len(m.CongestionControls); n > 0 {
+		tests *= n
+	}
+	return tests
+}
+
+// MaxProbeDuration returns the longest a probe of the module takes, with
+// every test running into its timeout.
+func (m *Module) MaxProbeDuration() time.Duration {
+	return time.Duration(m.Tests()) * m.ProbeTimeout()
+}
+
+// TOSByte returns the type of service byte to mark the test traffic with.
+func (m *Module) TOSByte() int {
+	if m.DSCP != "" {
+		dscp, _ := ParseDSCP(m.DSCP)
+		return dscp << 2
+	}
+	return m.TOS
+}
+
+var dscpClasses = map[string]int{
+	"be": 0, "default": 0, "le": 1,
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"va": 44, "ef": 46,
+}
+
+// ParseDSCP returns the code point of a DSCP class name like ef or af41, or
+// of a number between 0 and 63.
+func ParseDSCP(s string) (int, error) {
+	if dscp, ok := dscpClasses[strings.ToLower(s)]; ok {
+		return dscp, nil
+	}
+	dscp, err := strconv.Atoi(s)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("unknown DSCP class %q", s)
+	}
+	return dscp, nil
+}
+
+// Schedule probes a target periodically in the background.
+type Schedule struct {
+	Target   string            `yaml:"target"`
+	Module   string            `yaml:"module"`
+	Interval time.Duration     `yaml:"interval"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// FileSD schedules probes of the targets listed in Prometheus file_sd files
+// matching Files. The files are read again every RefreshInterval.
+type FileSD struct {
+	Files           []string          `yaml:"files"`
+	Module          string            `yaml:"module"`
+	Interval        time.Duration     `yaml:"interval"`
+	RefreshInterval time.Duration     `yaml:"refresh_interval"`
+	Labels          map[string]string `yaml:"labels"`
+}
+
+// UDPSearch searches for the highest UDP bitrate at which at most
+// LossThreshold percent of the packets are lost, by binary search down to
+// Precision or by raising the bitrate by Step.
+type UDPSearch struct {
+	Method        string  `yaml:"method"`
+	MinBitrate    int64   `yaml:"min_bitrate"`
+	MaxBitrate    int64   `yaml:"max_bitrate"`
+	Step          int64   `yaml:"step"`
+	Precision     int64   `yaml:"precision"`
+	LossThreshold float64 `yaml:"loss_threshold"`
+}
+
+// RFC6349 is the baseline round trip time and bottleneck bandwidth in bits
+// per second of a path, as measured in steps 1 and 2 of RFC 6349.
+type RFC6349 struct {
+	BaselineRTT         time.Duration `yaml:"baseline_rtt"`
+	BottleneckBandwidth int64         `yaml:"bottleneck_bandwidth"`
+}
+
+func (r *RFC6349) Validate() error {
+	if r.BaselineRTT <= 0 {
+		return fmt.Errorf("baseline_rtt must be positive")
+	}
+	if r.BottleneckBandwidth <= 0 {
+		return fmt.Errorf("bottleneck_bandwidth must be positive")
+	}
+	return nil
+}
+
+// MSSSweep lists the MSS values to test, in ascending order. A test reaches
+// full throughput if it achieves FullThroughput times the throughput of the
+// best test.
+type MSSSweep struct {
+	MSS            []int   `yaml:"mss"`
+	FullThroughput float64 `yaml:"full_throughput"`
+}
+
+func (s *MSSSweep) Validate() error {
+	if len(s.MSS) < 2 {
+		return fmt.Errorf("mss must list at least two values")
+	}
+	if len(s.MSS) > maxSearchSteps {
+		return fmt.Errorf("mss must list at most %d values", maxSearchSteps)
+	}
+	for i, mss := range s.MSS {
+		if mss < 535 {
+			return fmt.Errorf("mss must be integers > 535")
+		}
+		if i > 0 && mss <= s.MSS[i-1] {
+			return fmt.Errorf("mss must be ascending")
+		}
+	}
+	if s.FullThroughput <= 0 || s.FullThroughput > 1 {
+		return fmt.Errorf("full_throughput must be between 0 and 1")
+	}
+	return nil
+}
+
+// maxStreams is the most parallel streams iperf3 accepts.
+const maxStreams = 128
+
+func validateStreamSweep(streams []int) error {
+	if len(streams) < 2 {
+		return fmt.Errorf("must list at least two stream counts")
+	}
+	for i, n := range streams {
+		if n < 1 || n > maxStreams {
+			return fmt.Errorf("stream counts must be between 1 and %d", maxStreams)
+		}
+		if i > 0 && n <= streams[i-1] {
+			return fmt.Errorf("stream counts must be ascending")
+		}
+	}
+	return nil
+}
+
+// maxSearchSteps bounds the tests of a search or sweep, as they all run
+// within one probe.
+const maxSearchSteps = 64
+
+// Steps returns the most tests the search runs. A binary search tests both
+// ends of the range and then halves it until it is at most Precision wide.
+func (s *UDPSearch) Steps() int {
+	if s.Method == SearchRamp {
+		if s.Step <= 0 {
+			return 1
+		}
+		return int((s.MaxBitrate-s.MinBitrate)/s.Step) + 1
+	}
+	steps := 2
+	for width := s.MaxBitrate - s.MinBitrate; width > s.Precision && width > 1; steps++ {
+		width -= width / 2
+	}
+	return steps
+}
+
+func (s *UDPSearch) Validate() error {
+	if s.MinBitrate <= 0 || s.MaxBitrate <= s.MinBitrate {
+		return fmt.Errorf("min_bitrate must be positive and below max_bitrate")
+	}
+	if s.LossThreshold < 0 || s.LossThreshold >= 100 {
+		return fmt.Errorf("loss_threshold must be a percentage below 100")
+	}
+	switch s.Method {
+	case SearchBinary:
+		if s.Step != 0 {
+			return fmt.Errorf("step is only valid with method %q", SearchRamp)
+		}
+		if s.Precision <= 0 {
+			return fmt.Errorf("precision must be positive")
+		}
+	case SearchRamp:
+		if s.Precision != 0 {
+			return fmt.Errorf("precision is only valid with method %q", SearchBinary)
+		}
+		if s.Step <= 0 {
+			return fmt.Errorf("step must be positive")
+		}
+		if (s.MaxBitrate-s.MinBitrate)/s.Step+1 > maxSearchSteps {
+			return fmt.Errorf("step must allow at most %d steps", maxSearchSteps)
+		}
+	default:
+		return fmt.Errorf("method must be %q or %q", SearchBinary, SearchRamp)
+	}
+	return nil
+}
+
+// Budget limits the bytes transferred by probes of a target, or of all
+// targets if Target is empty, within a rolling window. Once exceeded, probes
+// are blocked or run with the module named by Module instead.
+type Budget struct {
+	Target string        `yaml:"target"`
+	Window time.Duration `yaml:"window"`
+	Bytes  int64         `yaml:"bytes"`
+	Action string        `yaml:"action"`
+	Module string        `yaml:"module"`
+}
+
+func LoadFile(path string, defaults Module) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(content, defaults)
+}
+
+// Load parses a configuration. Module fields not set in the configuration
+// are taken from defaults, and a module named "default" is always present.
+func Load(content []byte, defaults Module) (*Config, error) {
+	raw := struct {
+		Modules   map[string]yaml.MapSlice `yaml:"modules"`
+		Schedules []*Schedule              `yaml:"schedules"`
+		FileSD    []*FileSD                `yaml:"file_sd_configs"`
+		Budgets   []*Budget                `yaml:"budgets"`
+	}{}
+	if err := yaml.UnmarshalStrict(content, &raw); err != nil {
+		return nil, err
+	}
+
+	c := &Config{Modules: map[string]*Module{}}
+	for name, fields := range raw.Modules {
+		module := defaults
+		body, err := yaml.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(body, &module); err != nil {
+			return nil, fmt.Errorf("module %q: %w", name, err)
+		}
+		if search := module.UDPSearch; search != nil {
+			if search.Method == "" {
+				search.Method = SearchBinary
+			}
+			if search.Method == SearchBinary && search.Precision == 0 && search.MaxBitrate >= 100 {
+				search.Precision = search.MaxBitrate / 100
+			}
+		}
+		if sweep := module.MSSSweep; sweep != nil && sweep.FullThroughput == 0 {
+			sweep.FullThroughput = 0.9
+		}
+		if module.Resolve == ResolveAll && module.MaxAddresses == 0 {
+			module.MaxAddresses = DefaultMaxAddresses
+		}
+		if err := module.Validate(); err != nil {
+			return nil, fmt.Errorf("module %q: %w", name, err)
+		}
+		c.Modules[name] = &module
+	}
+	if _, ok := c.Modules[DefaultModule]; !ok {
+		module := defaults
+		c.Modules[DefaultModule] = &module
+	}
+
+	for i, schedule := range raw.Schedules {
+		if schedule.Module == "" {
+			schedule.Module = DefaultModule
+		}
+		if err := c.ValidateSchedule(schedule); err != nil {
+			return nil, fmt.Errorf("schedule %d: %w", i, err)
+		}
+		c.Schedules = append(c.Schedules, schedule)
+	}
+
+	for i, sd := range raw.FileSD {
+		if sd.Module == "" {
+			sd.Module = DefaultModule
+		}
+		if sd.RefreshInterval == 0 {
+			sd.RefreshInterval = time.Minute
+		}
+		if err := c.ValidateFileSD(sd); err != nil {
+			return nil, fmt.Errorf("file_sd_configs %d: %w", i, err)
+		}
+		c.FileSD = append(c.FileSD, sd)
+	}
+
+	windows := map[string]bool{}
+	for i, budget := range raw.Budgets {
+		key := budget.Target + "/" + budget.Window.String()
+		if windows[key] {
+			return nil, fmt.Errorf("budget %d: duplicate window %s for target %q", i, budget.Window, budget.Target)
+		}
+		windows[key] = true
+		if budget.Action == "" {
+			budget.Action = BudgetActionBlock
+		}
+		if err := c.ValidateBudget(budget); err != nil {
+			return nil, fmt.Errorf("budget %d: %w", i, err)
+		}
+		c.Budgets = append(c.Budgets, budget)
+	}
+	return c, nil
+}
+
+func (c *Config) ValidateSchedule(s *Schedule) error {
+	if s.Target == "" {
+		return fmt.Errorf("target must be specified")
+	}
+	module, ok := c.Modules[s.Module]
+	if !ok {
+		return fmt.Errorf("unknown module %q", s.Module)
+	}
+	if s.Interval <= module.MaxProbeDuration() {
+		return fmt.Errorf("interval must exceed the longest duration %s of module %q", module.MaxProbeDuration(), s.Module)
+	}
+	return nil
+}
+
+func (c *Config) ValidateFileSD(sd *FileSD) error {
+	if len(sd.Files) == 0 {
+		return fmt.Errorf("files must be specified")
+	}
+	for _, pattern := range sd.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if sd.RefreshInterval < time.Second {
+		return fmt.Errorf("refresh_interval must be at least 1s")
+	}
+	module, ok := c.Modules[sd.Module]
+	if !ok {
+		return fmt.Errorf("unknown module %q", sd.Module)
+	}
+	if sd.Interval <= module.MaxProbeDuration() {
+		return fmt.Errorf("interval must exceed the longest duration %s of module %q", module.MaxProbeDuration(), sd.Module)
+	}
+	return nil
+}
+
+func (c *Config) ValidateBudget(b *Budget) error {
+	if b.Window < time.Hour {
+		return fmt.Errorf("window must be at least 1h")
+	}
+	if b.Bytes <= 0 {
+		return fmt.Errorf("bytes must be positive")
+	}
+	switch b.Action {
+	case BudgetActionBlock:
+		if b.Module != "" {
+			return fmt.Errorf("module is only valid with action %q", BudgetActionDowngrade)
+		}
+	case BudgetActionDowngrade:
+		if _, ok := c.Modules[b.Module]; !ok {
+			return fmt.Errorf("unknown module %q", b.Module)
+		}
+	default:
+		return fmt.Errorf("action must be %q or %q", BudgetActionBlock, BudgetActionDowngrade)
+	}
+	return nil
+}
+
+func (m *Module) Validate() error {
+	if m.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if m.OmitDuration < 0 {
+		return fmt.Errorf("omit_duration must not be negative")
+	}
+	if m.MSS < 535 {
+		return fmt.Errorf("mss must be integer > 535")
+	}
+	if m.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	if m.Bytes < 0 || m.Blocks < 0 {
+		return fmt.Errorf("bytes and blocks must not be negative")
+	}
+	if m.Bytes != 0 && m.Blocks != 0 {
+		return fmt.Errorf("bytes and blocks are mutually exclusive")
+	}
+	if m.MinBitrate < 0 {
+		return fmt.Errorf("min_bitrate must not be negative")
+	}
+	if m.MinBitrate != 0 && m.TransferBytes() == 0 {
+		return fmt.Errorf("min_bitrate requires bytes or blocks")
+	}
+	if m.MinBitrate == 0 && m.TransferBytes() != 0 {
+		return fmt.Errorf("bytes and blocks require min_bitrate")
+	}
+	if m.TransferBytes() == 0 && m.Timeout <= m.Duration+m.OmitDuration {
+		return fmt.Errorf("timeout must exceed duration plus omit_duration")
+	}
+	switch m.Resolve {
+	case "", ResolveFirst, ResolveAll:
+	default:
+		return fmt.Errorf("resolve must be %q or %q", ResolveFirst, ResolveAll)
+	}
+	if m.SRV && m.Resolve == "" {
+		return fmt.Errorf("srv requires resolve")
+	}
+	if m.MaxAddresses < 0 {
+		return fmt.Errorf("max_addresses must not be negative")
+	}
+	if m.MaxAddresses != 0 && m.Resolve != ResolveAll {
+		return fmt.Errorf("max_addresses requires resolve %q", ResolveAll)
+	}
+	switch m.IPProtocol {
+	case "", IPProtocol4, IPProtocol6, IPProtocolPrefer:
+	default:
+		return fmt.Errorf("ip_protocol must be %q, %q or %q", IPProtocol4, IPProtocol6, IPProtocolPrefer)
+	}
+	switch m.PreferredIPProtocol {
+	case "", IPProtocol4, IPProtocol6:
+	default:
+		return fmt.Errorf("preferred_ip_protocol must be %q or %q", IPProtocol4, IPProtocol6)
+	}
+	if m.DualStack && m.IPProtocol != "" {
+		return fmt.Errorf("dual_stack and ip_protocol are mutually exclusive")
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if m.ClientPort < 0 || m.ClientPort > 65535 {
+		return fmt.Errorf("client_port must be between 1 and 65535")
+	}
+	if m.IdleLatency && m.IdleLatencyPort == 0 {
+		return fmt.Errorf("idle_latency requires idle_latency_port")
+	}
+	if m.IdleLatencyPort != 0 && !m.IdleLatency {
+		return fmt.Errorf("idle_latency_port requires idle_latency")
+	}
+	if m.IdleLatencyPort < 0 || m.IdleLatencyPort > 65535 {
+		return fmt.Errorf("idle_latency_port must be between 1 and 65535")
+	}
+	if m.DSCP != "" {
+		if _, err := ParseDSCP(m.DSCP); err != nil {
+			return fmt.Errorf("dscp: %w", err)
+		}
+	}
+	if m.TOS < 0 || m.TOS > 255 {
+		return fmt.Errorf("tos must be between 0 and 255")
+	}
+	if m.DSCP != "" && m.TOS != 0 {
+		return fmt.Errorf("dscp and tos are mutually exclusive")
+	}
+	seen := map[string]bool{}
+	for _, class := range m.QoSClasses {
+		if _, err := ParseDSCP(class); err != nil {
+			return fmt.Errorf("qos_classes: %w", err)
+		}
+		if seen[strings.ToLower(class)] {
+			return fmt.Errorf("qos_classes: duplicate class %q", class)
+		}
+		seen[strings.ToLower(class)] = true
+	}
+	if len(m.QoSClasses) > 0 && (m.DSCP != "" || m.TOS != 0) {
+		return fmt.Errorf("qos_classes and dscp or tos are mutually exclusive")
+	}
+	if m.Bitrate < 0 {
+		return fmt.Errorf("bitrate must not be negative")
+	}
+	if m.UDPSearch != nil {
+		if !m.UDP {
+			return fmt.Errorf("udp_search requires udp")
+		}
+		if m.Bitrate != 0 {
+			return fmt.Errorf("udp_search and bitrate are mutually exclusive")
+		}
+		if err := m.UDPSearch.Validate(); err != nil {
+			return fmt.Errorf("udp_search: %w", err)
+		}
+	}
+	if m.MSSSweep != nil {
+		if m.UDP {
+			return fmt.Errorf("mss_sweep is only valid with TCP")
+		}
+		if err := m.MSSSweep.Validate(); err != nil {
+			return fmt.Errorf("mss_sweep: %w", err)
+		}
+	}
+	if len(m.StreamSweep) > 0 {
+		if err := validateStreamSweep(m.StreamSweep); err != nil {
+			return fmt.Errorf("stream_sweep: %w", err)
+		}
+		if m.UDPSearch != nil || m.MSSSweep != nil {
+			return fmt.Errorf("stream_sweep, udp_search and mss_sweep are mutually exclusive")
+		}
+	}
+	if m.RFC6349 != nil {
+		if m.UDP {
+			return fmt.Errorf("rfc6349 is only valid with TCP")
+		}
+		if err := m.RFC6349.Validate(); err != nil {
+			return fmt.Errorf("rfc6349: %w", err)
+		}
+	}
+	if m.UDP && (m.CongestionControl != "" || len(m.CongestionControls) > 0) {
+		return fmt.Errorf("congestion control is only valid with TCP")
+	}
+	if m.CongestionControl != "" {
+		if err := ValidateCongestionControl(m.CongestionControl); err != nil {
+			return fmt.Errorf("congestion_control: %w", err)
+		}
+	}
+	algorithms := map[string]bool{}
+	for _, algorithm := range m.CongestionControls {
+		if err := ValidateCongestionControl(algorithm); err != nil {
+			return fmt.Errorf("congestion_controls: %w", err)
+		}
+		if algorithms[algorithm] {
+			return fmt.Errorf("congestion_controls: duplicate algorithm %q", algorithm)
+		}
+		algorithms[algorithm] = true
+	}
+	if m.CongestionControl != "" && len(m.CongestionControls) > 0 {
+		return fmt.Errorf("congestion_control and congestion_controls are mutually exclusive")
+	}
+	if tests := m.Tests(); tests > maxProbeTests {
+		return fmt.Errorf("a probe would run up to %d tests, at most %d are allowed", tests, maxProbeTests)
+	}
+	if m.Netns != "" {
+		if _, err := os.Stat(m.NetnsPath()); err != nil {
+			return fmt.Errorf("netns: %w", err)
+		}
+	}
+	// Addresses and interfaces of another namespace cannot be checked here.
+	if m.BindAddress != "" && m.Netns == "" {
+		if err := validateBindAddress(m.BindAddress); err != nil {
+			return err
+		}
+	}
+	if err := m.ValidateBindAddressFamily(); err != nil {
+		return err
+	}
+	if m.Interface != "" && m.Netns == "" {
+		if _, err := net.InterfaceByName(m.Interface); err != nil {
+			return fmt.Errorf("interface %q: %w", m.Interface, err)
+		}
+	}
+	return nil
+}
+
+// ValidateBindAddressFamily checks that the bind address, if any, is of the
+// IP protocol of the module.
+func (m *Module) ValidateBindAddressFamily() error {
+	if m.BindAddress == "" {
+		return nil
+	}
+	v4 := net.ParseIP(m.BindAddress).To4() != nil
+	if m.DualStack || m.IPProtocol == IPProtocol6 && v4 || m.IPProtocol == IPProtocol4 && !v4 {
+		return fmt.Errorf("bind_address %s does not match the IP protocol of the module", m.BindAddress)
+	}
+	return nil
+}
+
+// validateBindAddress checks that address is an IP address of a local
+// interface.
+func validateBindAddress(address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("bind_address %q is not an IP address", address)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bind_address %s is not assigned to a local interface", address)
+}
+
+// ValidateCongestionControl checks the name of a congestion control
+// algorithm. Whether the kernel supports it is only known once iperf3 runs,
+// as algorithms may be loaded on demand.
+func ValidateCongestionControl(algorithm string) error {
+	if len(algorithm) > 15 {
+		return fmt.Errorf("algorithm %q is too long", algorithm)
+	}
+	for _, r := range algorithm {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid algorithm %q", algorithm)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDefaults = Module{
+	Timeout:      30 * time.Second,
+	Duration:     10 * time.Second,
+	OmitDuration: 5 * time.Second,
+	MSS:          1400,
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(nil, testDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, ok := c.Modules[DefaultModule]
+	if !ok {
+		t.Fatalf("module %q missing", DefaultModule)
+	}
+	if module.Duration != testDefaults.Duration || module.MSS != testDefaults.MSS {
+		t.Errorf("default module = %+v, want the defaults", module)
+	}
+}
+
+func TestLoadModuleInheritsDefaults(t *testing.T) {
+	c, err := Load([]byte(`
+modules:
+  short:
+    duration: 2s
+    timeout: 10s
+`), testDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := c.Modules["short"]
+	if module.Duration != 2*time.Second || module.Timeout != 10*time.Second {
+		t.Errorf("duration and timeout = %s and %s, want 2s and 10s", module.Duration, module.Timeout)
+	}
+	if module.OmitDuration != testDefaults.OmitDuration || module.MSS != testDefaults.MSS {
+		t.Errorf("omit_duration and mss = %s and %d, want the defaults", module.OmitDuration, module.MSS)
+	}
+}
+
+func TestLoadSetsDefaults(t *testing.T) {
+	c, err := Load([]byte(`
+modules:
+  search:
+    udp: true
+    udp_search:
+      min_bitrate: 1000000
+      max_bitrate: 100000000
+  mtu:
+    mss_sweep:
+      mss: [536, 1460]
+  all:
+    resolve: all
+schedules:
+  - target: example.com
+    interval: 1h
+    module: search
+budgets:
+  - window: 24h
+    bytes: 1000000000
+`), testDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := c.Modules["search"].UDPSearch
+	if search.Method != SearchBinary || search.Precision != 1000000 {
+		t.Errorf("udp_search method and precision = %q and %d, want %q and 1000000", search.Method, search.Precision, SearchBinary)
+	}
+	if sweep := c.Modules["mtu"].MSSSweep; sweep.FullThroughput != 0.9 {
+		t.Errorf("full_throughput = %g, want 0.9", sweep.FullThroughput)
+	}
+	if all := c.Modules["all"]; all.MaxAddresses != DefaultMaxAddresses {
+		t.Errorf("max_addresses = %d, want %d", all.MaxAddresses, DefaultMaxAddresses)
+	}
+	if budget := c.Budgets[0]; budget.Action != BudgetActionBlock {
+		t.Errorf("budget action = %q, want %q", budget.Action, BudgetActionBlock)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "unknown field",
+			config: "modules:\n  m:\n    durration: 1s\n",
+			err:    "field durration not found",
+		},
+		{
+			name:   "timeout below duration",
+			config: "modules:\n  m:\n    duration: 30s\n",
+			err:    "timeout must exceed duration plus omit_duration",
+		},
+		{
+			name:   "small mss",
+			config: "modules:\n  m:\n    mss: 100\n",
+			err:    "mss must be integer > 535",
+		},
+		{
+			name:   "bytes and blocks",
+			config: "modules:\n  m:\n    bytes: 1000\n    blocks: 10\n",
+			err:    "bytes and blocks are mutually exclusive",
+		},
+		{
+			name:   "min_bitrate without transfer",
+			config: "modules:\n  m:\n    min_bitrate: 1000\n",
+			err:    "min_bitrate requires bytes or blocks",
+		},
+		{
+			name:   "transfer without min_bitrate",
+			config: "modules:\n  m:\n    blocks: 100\n",
+			err:    "bytes and blocks require min_bitrate",
+		},
+		{
+			name:   "unknown resolve",
+			config: "modules:\n  m:\n    resolve: some\n",
+			err:    "resolve must be",
+		},
+		{
+			name:   "srv without resolve",
+			config: "modules:\n  m:\n    srv: true\n",
+			err:    "srv requires resolve",
+		},
+		{
+			name:   "max_addresses without resolve all",
+			config: "modules:\n  m:\n    resolve: first\n    max_addresses: 2\n",
+			err:    `max_addresses requires resolve "all"`,
+		},
+		{
+			name:   "too many tests",
+			config: "modules:\n  m:\n    qos_classes: [be, cs1, af11, af21, af31, af41, ef, cs6, cs7]\n    congestion_controls: [bbr, cubic, reno, vegas, westwood, htcp, illinois, dctcp]\n",
+			err:    "a probe would run up to 72 tests, at most 64 are allowed",
+		},
+		{
+			name:   "too many search steps",
+			config: "modules:\n  m:\n    udp: true\n    qos_classes: [be, ef]\n    udp_search: {method: ramp, min_bitrate: 10, max_bitrate: 400, step: 10}\n",
+			err:    "a probe would run up to 80 tests, at most 64 are allowed",
+		},
+		{
+			name:   "idle_latency without port",
+			config: "modules:\n  m:\n    idle_latency: true\n",
+			err:    "idle_latency requires idle_latency_port",
+		},
+		{
+			name:   "idle_latency_port without idle_latency",
+			config: "modules:\n  m:\n    idle_latency_port: 7\n",
+			err:    "idle_latency_port requires idle_latency",
+		},
+		{
+			name:   "idle_latency_port out of range",
+			config: "modules:\n  m:\n    idle_latency: true\n    idle_latency_port: 65536\n",
+			err:    "idle_latency_port must be between 1 and 65535",
+		},
+		{
+			name:   "dual stack with ip_protocol",
+			config: "modules:\n  m:\n    dual_stack: true\n    ip_protocol: ip4\n",
+			err:    "dual_stack and ip_protocol are mutually exclusive",
+		},
+		{
+			name:   "port out of range",
+			config: "modules:\n  m:\n    port: 70000\n",
+			err:    "port must be between 1 and 65535",
+		},
+		{
+			name:   "unknown dscp",
+			config: "modules:\n  m:\n    dscp: af99\n",
+			err:    `unknown DSCP class "af99"`,
+		},
+		{
+			name:   "dscp and tos",
+			config: "modules:\n  m:\n    dscp: ef\n    tos: 184\n",
+			err:    "dscp and tos are mutually exclusive",
+		},
+		{
+			name:   "duplicate qos class",
+			config: "modules:\n  m:\n    qos_classes: [ef, EF]\n",
+			err:    `duplicate class "EF"`,
+		},
+		{
+			name:   "udp_search without udp",
+			config: "modules:\n  m:\n    udp_search: {min_bitrate: 1, max_bitrate: 100}\n",
+			err:    "udp_search requires udp",
+		},
+		{
+			name:   "ramp without step",
+			config: "modules:\n  m:\n    udp: true\n    udp_search: {method: ramp, min_bitrate: 1, max_bitrate: 100}\n",
+			err:    "step must be positive",
+		},
+		{
+			name:   "mss_sweep with udp",
+			config: "modules:\n  m:\n    udp: true\n    mss_sweep: {mss: [536, 1460]}\n",
+			err:    "mss_sweep is only valid with TCP",
+		},
+		{
+			name:   "descending mss_sweep",
+			config: "modules:\n  m:\n    mss_sweep: {mss: [1460, 536]}\n",
+			err:    "mss must be ascending",
+		},
+		{
+			name:   "stream_sweep out of range",
+			config: "modules:\n  m:\n    stream_sweep: [1, 256]\n",
+			err:    "stream counts must be between 1 and 128",
+		},
+		{
+			name:   "rfc6349 with udp",
+			config: "modules:\n  m:\n    udp: true\n    rfc6349: {baseline_rtt: 10ms, bottleneck_bandwidth: 1000000}\n",
+			err:    "rfc6349 is only valid with TCP",
+		},
+		{
+			name:   "invalid congestion control",
+			config: "modules:\n  m:\n    congestion_control: BBR\n",
+			err:    `invalid algorithm "BBR"`,
+		},
+		{
+			name:   "congestion control with udp",
+			config: "modules:\n  m:\n    udp: true\n    congestion_control: bbr\n",
+			err:    "congestion control is only valid with TCP",
+		},
+		{
+			name:   "missing netns",
+			config: "modules:\n  m:\n    netns: /nonexistent/netns\n",
+			err:    "netns:",
+		},
+		{
+			name:   "schedule of unknown module",
+			config: "schedules:\n  - target: example.com\n    module: m\n    interval: 1h\n",
+			err:    `unknown module "m"`,
+		},
+		{
+			name:   "schedule interval below timeout",
+			config: "schedules:\n  - target: example.com\n    interval: 10s\n",
+			err:    "interval must exceed",
+		},
+		{
+			name:   "schedule interval below longest probe",
+			config: "modules:\n  m:\n    dual_stack: true\n    qos_classes: [be, af41, ef]\nschedules:\n  - target: example.com\n    module: m\n    interval: 2m\n",
+			err:    `interval must exceed the longest duration 3m0s of module "m"`,
+		},
+		{
+			name:   "file_sd interval below longest probe",
+			config: "modules:\n  m:\n    resolve: all\nfile_sd_configs:\n  - files: [targets.json]\n    module: m\n    interval: 1m\n",
+			err:    `interval must exceed the longest duration 2m0s of module "m"`,
+		},
+		{
+			name:   "file_sd without files",
+			config: "file_sd_configs:\n  - interval: 1h\n",
+			err:    "files must be specified",
+		},
+		{
+			name:   "short budget window",
+			config: "budgets:\n  - window: 10m\n    bytes: 1000\n",
+			err:    "window must be at least 1h",
+		},
+		{
+			name:   "duplicate budget window",
+			config: "budgets:\n  - window: 1h\n    bytes: 1000\n  - window: 1h\n    bytes: 2000\n",
+			err:    "duplicate window",
+		},
+		{
+			name:   "downgrade to unknown module",
+			config: "budgets:\n  - window: 1h\n    bytes: 1000\n    action: downgrade\n    module: m\n",
+			err:    `unknown module "m"`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load([]byte(test.config), testDefaults)
+			if err == nil {
+				t.Fatalf("Load succeeded, want error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Load error = %q, want it to contain %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseDSCP(t *testing.T) {
+	for _, test := range []struct {
+		class string
+		dscp  int
+		ok    bool
+	}{
+		{"ef", 46, true},
+		{"AF41", 34, true},
+		{"cs1", 8, true},
+		{"0", 0, true},
+		{"63", 63, true},
+		{"64", 0, false},
+		{"-1", 0, false},
+		{"gold", 0, false},
+	} {
+		dscp, err := ParseDSCP(test.class)
+		if (err == nil) != test.ok || dscp != test.dscp {
+			t.Errorf("ParseDSCP(%q) = %d, %v, want %d and ok %t", test.class, dscp, err, test.dscp, test.ok)
+		}
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	module := testDefaults
+	if got := module.ProbeTimeout(); got != module.Timeout {
+		t.Errorf("ProbeTimeout() = %s, want the timeout %s", got, module.Timeout)
+	}
+
+	// 100 MB at 100 Mbit/s take 8s.
+	module.Bytes = 100000000
+	module.MinBitrate = 100000000
+	if got, want := module.ProbeTimeout(), module.Timeout+module.OmitDuration+8*time.Second; got != want {
+		t.Errorf("ProbeTimeout() = %s, want %s", got, want)
+	}
+
+	// 1000 UDP blocks of 1460 bytes at 1.168 Mbit/s take 10s.
+	module.Bytes = 0
+	module.Blocks = 1000
+	module.UDP = true
+	module.MinBitrate = 1168000
+	if got, want := module.ProbeTimeout(), module.Timeout+module.OmitDuration+10*time.Second; got != want {
+		t.Errorf("ProbeTimeout() of UDP blocks = %s, want %s", got, want)
+	}
+}
+
+func TestMaxProbeDuration(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(m *Module)
+		tests  int
+	}{
+		{"single test", func(m *Module) {}, 1},
+		{"resolve first", func(m *Module) { m.Resolve = ResolveFirst }, 1},
+		{"resolve all", func(m *Module) {
+			m.Resolve = ResolveAll
+			m.MaxAddresses = 3
+		}, 3},
+		{"dual stack", func(m *Module) { m.DualStack = true }, 2},
+		{"everything", func(m *Module) {
+			m.Resolve = ResolveAll
+			m.MaxAddresses = 2
+			m.DualStack = true
+			m.QoSClasses = []string{"be", "ef"}
+			m.CongestionControls = []string{"bbr", "cubic", "reno"}
+		}, 24},
+		{"search", func(m *Module) {
+			m.DualStack = true
+			m.UDPSearch = &UDPSearch{Method: SearchRamp, MinBitrate: 10, MaxBitrate: 100, Step: 10}
+		}, 20},
+		{"mss sweep", func(m *Module) {
+			m.CongestionControls = []string{"bbr", "cubic"}
+			m.MSSSweep = &MSSSweep{MSS: []int{536, 1220, 1460}, FullThroughput: 0.9}
+		}, 6},
+		{"stream sweep", func(m *Module) {
+			m.Resolve = ResolveAll
+			m.MaxAddresses = 2
+			m.StreamSweep = []int{1, 2, 4, 8}
+		}, 8},
+	} {
+		module := testDefaults
+		test.modify(&module)
+		if got := module.Tests(); got != test.tests {
+			t.Errorf("%s: Tests() = %d, want %d", test.name, got, test.tests)
+		}
+		if got, want := module.MaxProbeDuration(), time.Duration(test.tests)*module.Timeout; got != want {
+			t.Errorf("%s: MaxProbeDuration() = %s, want %s", test.name, got, want)
+		}
+	}
+}
+
+func TestUDPSearchSteps(t *testing.T) {
+	for _, test := range []struct {
+		search UDPSearch
+		steps  int
+	}{
+		{UDPSearch{Method: SearchRamp, MinBitrate: 10, MaxBitrate: 100, Step: 10}, 10},
+		{UDPSearch{Method: SearchRamp, MinBitrate: 10, MaxBitrate: 105, Step: 10}, 10},
+		// 990 Mbit/s wide, halved seven times down to 7.7 Mbit/s.
+		{UDPSearch{Method: SearchBinary, MinBitrate: 10000000, MaxBitrate: 1000000000, Precision: 10000000}, 9},
+		{UDPSearch{Method: SearchBinary, MinBitrate: 10, MaxBitrate: 20, Precision: 10}, 2},
+		// 7 is halved to 4, 2 and 1, always keeping the larger half.
+		{UDPSearch{Method: SearchBinary, MinBitrate: 1, MaxBitrate: 8, Precision: 1}, 5},
+	} {
+		if got := test.search.Steps(); got != test.steps {
+			t.Errorf("%+v.Steps() = %d, want %d", test.search, got, test.steps)
+		}
+	}
+}
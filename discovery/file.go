@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetGroup is a group of targets sharing labels, as in the file_sd
+// format of Prometheus.
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// ReadFile parses a file_sd file. Files ending in .json are parsed as JSON,
+// .yml and .yaml as YAML.
+func ReadFile(path string) ([]*TargetGroup, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*TargetGroup{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &groups)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(content, &groups)
+	default:
+		return nil, fmt.Errorf("unknown file_sd file extension of %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, group := range groups {
+		if group == nil {
+			return nil, fmt.Errorf("parsing %s: empty target group %d", path, i)
+		}
+		for _, target := range group.Targets {
+			if target == "" {
+				return nil, fmt.Errorf("parsing %s: empty target in group %d", path, i)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// File discovers target groups from the files matching a set of glob
+// patterns. The files are read again every refresh interval, so added,
+// changed and removed files are picked up.
+type File struct {
+	patterns []string
+	refresh  time.Duration
+	onChange func([]*TargetGroup)
+
+	// groups by file, kept if a file becomes unreadable
+	groups map[string][]*TargetGroup
+	last   []*TargetGroup
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewFile reads the files once and calls onChange with the target groups of
+// all files, then keeps watching them in the background until Stop is
+// called. onChange is called whenever the target groups change.
+func NewFile(patterns []string, refresh time.Duration, onChange func([]*TargetGroup)) *File {
+	f := &File{
+		patterns: patterns,
+		refresh:  refresh,
+		onChange: onChange,
+		groups:   map[string][]*TargetGroup{},
+		stopCh:   make(chan struct{}),
+	}
+	f.update()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.update()
+			case <-f.stopCh:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+func (f *File) Stop() {
+	close(f.stopCh)
+	f.wg.Wait()
+}
+
+func (f *File) update() {
+	files := map[string]bool{}
+	for _, pattern := range f.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"pattern": pattern,
+			}).Error("Invalid file_sd pattern")
+			continue
+		}
+		for _, match := range matches {
+			files[match] = true
+		}
+	}
+
+	for path := range f.groups {
+		if !files[path] {
+			delete(f.groups, path)
+		}
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+		groups, err := ReadFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": path,
+			}).Error("Reading file_sd file failed, keeping its previous targets")
+			continue
+		}
+		f.groups[path] = groups
+	}
+	sort.Strings(paths)
+
+	all := []*TargetGroup{}
+	for _, path := range paths {
+		all = append(all, f.groups[path]...)
+	}
+	if f.last != nil && reflect.DeepEqual(all, f.last) {
+		return
+	}
+	f.last = all
+	f.onChange(all)
+}
@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	want := []*TargetGroup{
+		{Targets: []string{"a.example.com", "b.example.com:5202"}, Labels: map[string]string{"site": "fra"}},
+		{Targets: []string{"c.example.com"}},
+	}
+	dir := t.TempDir()
+	for _, path := range []string{
+		writeFile(t, dir, "targets.json", `[
+			{"targets": ["a.example.com", "b.example.com:5202"], "labels": {"site": "fra"}},
+			{"targets": ["c.example.com"]}
+		]`),
+		writeFile(t, dir, "targets.yml", `
+- targets: [a.example.com, "b.example.com:5202"]
+  labels:
+    site: fra
+- targets: [c.example.com]
+`),
+		writeFile(t, dir, "targets.YAML", "- targets: [a.example.com, \"b.example.com:5202\"]\n  labels: {site: fra}\n- targets: [c.example.com]\n"),
+	} {
+		groups, err := ReadFile(path)
+		if err != nil {
+			t.Errorf("ReadFile(%s): %s", filepath.Base(path), err)
+			continue
+		}
+		if !reflect.DeepEqual(groups, want) {
+			t.Errorf("ReadFile(%s) = %v, want %v", filepath.Base(path), groups, want)
+		}
+	}
+}
+
+func TestReadFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"targets.txt", "a.example.com\n", "unknown file_sd file extension"},
+		{"malformed.json", `[{"targets": [}]`, "parsing"},
+		{"unknown.yml", "- targets: [a.example.com]\n  label: {site: fra}\n", "field label not found"},
+		{"empty-target.json", `[{"targets": ["a.example.com", ""]}]`, "empty target in group 0"},
+		{"empty-group.yml", "- targets: [a.example.com]\n-\n", "empty target group 1"},
+	} {
+		_, err := ReadFile(writeFile(t, dir, test.name, test.content))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ReadFile(%s) = %v, want error containing %q", test.name, err, test.err)
+		}
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadFile of a missing file succeeded, want error")
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.json", `[{"targets": ["a.example.com"]}]`)
+	updates := make(chan []*TargetGroup, 10)
+	f := NewFile([]string{filepath.Join(dir, "*.json")}, 10*time.Millisecond, func(groups []*TargetGroup) {
+		updates <- groups
+	})
+	defer f.Stop()
+
+	next := func() []*TargetGroup {
+		select {
+		case groups := <-updates:
+			return groups
+		case <-time.After(5 * time.Second):
+			t.Fatal("target groups not updated")
+			return nil
+		}
+	}
+	targets := func(groups []*TargetGroup) string {
+		var targets []string
+		for _, group := range groups {
+			targets = append(targets, group.Targets...)
+		}
+		return strings.Join(targets, ",")
+	}
+
+	if got := targets(next()); got != "a.example.com" {
+		t.Errorf("targets = %s, want a.example.com", got)
+	}
+	writeFile(t, dir, "b.json", `[{"targets": ["b.example.com"]}]`)
+	if got := targets(next()); got != "a.example.com,b.example.com" {
+		t.Errorf("targets = %s after adding a file, want a.example.com,b.example.com", got)
+	}
+	// A broken file keeps its previous targets.
+	writeFile(t, dir, "a.json", `[{"targets": [`)
+	writeFile(t, dir, "b.json", `[{"targets": ["c.example.com"]}]`)
+	if got := targets(next()); got != "a.example.com,c.example.com" {
+		t.Errorf("targets = %s after breaking a file, want a.example.com,c.example.com", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strconv"
+	"time"
+)
+
+var errNotPositive = errors.New("must be positive")
+
+// positiveInt is an int flag rejecting values below 1.
+type positiveInt int
+
+func (i *positiveInt) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+func (i *positiveInt) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return errNotPositive
+	}
+	*i = positiveInt(v)
+	return nil
+}
+
+// positiveDuration is a duration flag rejecting zero and negative values.
+type positiveDuration time.Duration
+
+func (d *positiveDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *positiveDuration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return errNotPositive
+	}
+	*d = positiveDuration(v)
+	return nil
+}
+
+// positiveIntFlag defines an int flag on flag.CommandLine that must be
+// positive.
+func positiveIntFlag(name string, value int, usage string) *int {
+	p := positiveInt(value)
+	flag.CommandLine.Var(&p, name, usage)
+	return (*int)(&p)
+}
+
+// positiveDurationFlag defines a duration flag on flag.CommandLine that must
+// be positive.
+func positiveDurationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := positiveDuration(value)
+	flag.CommandLine.Var(&p, name, usage)
+	return (*time.Duration)(&p)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestPositiveFlags(t *testing.T) {
+	defer func(commandLine *flag.FlagSet) { flag.CommandLine = commandLine }(flag.CommandLine)
+	for _, test := range []struct {
+		args []string
+		err  bool
+	}{
+		{nil, false},
+		{[]string{"-size", "1", "-interval", "1ms"}, false},
+		{[]string{"-size", "0"}, true},
+		{[]string{"-size", "-1"}, true},
+		{[]string{"-size", "x"}, true},
+		{[]string{"-interval", "0s"}, true},
+		{[]string{"-interval", "-1s"}, true},
+		{[]string{"-interval", "1"}, true},
+	} {
+		flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(ioutil.Discard)
+		size := positiveIntFlag("size", 1000, "")
+		interval := positiveDurationFlag("interval", 10*time.Second, "")
+		err := flag.CommandLine.Parse(test.args)
+		if (err != nil) != test.err {
+			t.Errorf("%v: error = %v, want error %t", test.args, err, test.err)
+		}
+		if test.args == nil && (*size != 1000 || *interval != 10*time.Second) {
+			t.Errorf("defaults are %d and %s, want 1000 and 10s", *size, *interval)
+		}
+	}
+}
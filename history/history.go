@@ -0,0 +1,397 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"math"
+	_ "modernc.org/sqlite"
+	"sort"
+	"sync"
+	"time"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS probes (
+	time                      INTEGER NOT NULL,
+	target                    TEXT    NOT NULL,
+	module                    TEXT    NOT NULL,
+	duration_seconds          REAL    NOT NULL,
+	sent_bits_per_second      REAL    NOT NULL,
+	received_bits_per_second  REAL    NOT NULL,
+	reverse                   INTEGER NOT NULL,
+	rtt_min_seconds           REAL,
+	rtt_mean_seconds          REAL,
+	rtt_max_seconds           REAL,
+	retransmits               INTEGER NOT NULL,
+	lost_percent              REAL,
+	jitter_seconds            REAL,
+	cpu_host_percent          REAL    NOT NULL,
+	cpu_remote_percent        REAL    NOT NULL,
+	labels                    TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS probes_target_time ON probes (target, time);
+CREATE INDEX IF NOT EXISTS probes_time ON probes (time);
+`
+
+// Summary condenses the results of a single probe. Pointer fields are nil if
+// the measurement is not available for the protocol used. Labels tell apart
+// probes of a target and module, e.g. of each IP version or QoS class.
+type Summary struct {
+	Time                  time.Time         `json:"time"`
+	Target                string            `json:"target"`
+	Module                string            `json:"module"`
+	Labels                map[string]string `json:"labels,omitempty"`
+	DurationSeconds       float64           `json:"duration_seconds"`
+	SentBitsPerSecond     float64           `json:"sent_bits_per_second"`
+	ReceivedBitsPerSecond float64           `json:"received_bits_per_second"`
+	Reverse               bool              `json:"reverse"`
+	RTTMinSeconds         *float64          `json:"rtt_min_seconds"`
+	RTTMeanSeconds        *float64          `json:"rtt_mean_seconds"`
+	RTTMaxSeconds         *float64          `json:"rtt_max_seconds"`
+	Retransmits           int               `json:"retransmits"`
+	LostPercent           *float64          `json:"lost_percent"`
+	JitterSeconds         *float64          `json:"jitter_seconds"`
+	CPUHostPercent        float64           `json:"cpu_host_percent"`
+	CPURemotePercent      float64           `json:"cpu_remote_percent"`
+}
+
+// Summarize extracts the summary of a probe record.
+func Summarize(record *collector.Record) *Summary {
+	r := record.Results
+	s := &Summary{
+		Time:            record.End,
+		Target:          record.Target,
+		Module:          record.Module,
+		Labels:          record.Labels,
+		DurationSeconds: record.End.Sub(record.Start).Seconds(),
+	}
+	if r.Start != nil && r.Start.TestStart != nil {
+		s.Reverse = r.Start.TestStart.Reverse > 0
+	}
+	if r.End == nil {
+		return s
+	}
+
+	if r.End.SummarySent != nil {
+		s.SentBitsPerSecond = r.End.SummarySent.BitsPerSecond
+		s.Retransmits = r.End.SummarySent.Retransmits
+	}
+	if r.End.SummaryReceived != nil {
+		s.ReceivedBitsPerSecond = r.End.SummaryReceived.BitsPerSecond
+	}
+	if r.End.Summary != nil && r.End.Summary.Packets > 0 {
+		lost := r.End.Summary.LostPercent
+		jitter := r.End.Summary.JitterMs / 1000
+		s.LostPercent = &lost
+		s.JitterSeconds = &jitter
+		if r.End.SummarySent == nil {
+			s.SentBitsPerSecond = r.End.Summary.BitsPerSecond
+		}
+	}
+	if r.End.CpuUsage != nil {
+		s.CPUHostPercent = r.End.CpuUsage.HostTotal
+		s.CPURemotePercent = r.End.CpuUsage.RemoteTotal
+	}
+
+	var minRTT, maxRTT, sumRTT float64
+	streams := 0
+	for _, stream := range r.End.Streams {
+		if stream.Sender == nil || stream.Sender.MeanRoundTripTime == 0 {
+			continue
+		}
+		if streams == 0 || stream.Sender.MinRoundTripTime < minRTT {
+			minRTT = stream.Sender.MinRoundTripTime
+		}
+		if stream.Sender.MaxRoundTripTime > maxRTT {
+			maxRTT = stream.Sender.MaxRoundTripTime
+		}
+		sumRTT += stream.Sender.MeanRoundTripTime
+		streams++
+	}
+	if streams > 0 {
+		minRTT, maxRTT, meanRTT := minRTT/1000000, maxRTT/1000000, sumRTT/float64(streams)/1000000
+		s.RTTMinSeconds = &minRTT
+		s.RTTMeanSeconds = &meanRTT
+		s.RTTMaxSeconds = &maxRTT
+	}
+	return s
+}
+
+// Store keeps probe summaries in a SQLite database.
+type Store struct {
+	db        *sql.DB
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// Open opens or creates the database at path. Summaries older than
+// retention are deleted, unless retention is zero.
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+	return &Store{db: db, retention: retention}, nil
+}
+
+// migrate adds the labels column to databases created before it existed.
+func migrate(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('probes') WHERE name = 'labels'`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec(`ALTER TABLE probes ADD COLUMN labels TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+// encodeLabels returns labels as JSON, whose keys are sorted, or an empty
+// string if there are none.
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(labels)
+	return string(encoded), err
+}
+
+func decodeLabels(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	labels := map[string]string{}
+	return labels, json.Unmarshal([]byte(encoded), &labels)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Write(record *collector.Record) error {
+	return s.Insert(Summarize(record))
+}
+
+func (s *Store) Insert(summary *Summary) error {
+	labels, err := encodeLabels(summary.Labels)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO probes (
+		time, target, module, duration_seconds, sent_bits_per_second, received_bits_per_second, reverse,
+		rtt_min_seconds, rtt_mean_seconds, rtt_max_seconds, retransmits, lost_percent, jitter_seconds,
+		cpu_host_percent, cpu_remote_percent, labels
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		summary.Time.UnixNano(), summary.Target, summary.Module, summary.DurationSeconds,
+		summary.SentBitsPerSecond, summary.ReceivedBitsPerSecond, summary.Reverse,
+		summary.RTTMinSeconds, summary.RTTMeanSeconds, summary.RTTMaxSeconds, summary.Retransmits,
+		summary.LostPercent, summary.JitterSeconds, summary.CPUHostPercent, summary.CPURemotePercent, labels)
+	if err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+func (s *Store) prune() error {
+	if s.retention <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastPrune) < time.Minute {
+		return nil
+	}
+	s.lastPrune = time.Now()
+	_, err := s.db.Exec(`DELETE FROM probes WHERE time < ?`, time.Now().Add(-s.retention).UnixNano())
+	return err
+}
+
+// Query selects summaries of a time range. Target and Module are optional
+// filters.
+type Query struct {
+	Target string
+	Module string
+	From   time.Time
+	To     time.Time
+}
+
+func (q *Query) where() (string, []interface{}) {
+	clause := `time >= ? AND time <= ?`
+	args := []interface{}{q.From.UnixNano(), q.To.UnixNano()}
+	if q.Target != "" {
+		clause += ` AND target = ?`
+		args = append(args, q.Target)
+	}
+	if q.Module != "" {
+		clause += ` AND module = ?`
+		args = append(args, q.Module)
+	}
+	return clause, args
+}
+
+// Targets returns the targets with summaries in the time range.
+func (s *Store) Targets(q Query) ([]string, error) {
+	where, args := q.where()
+	rows, err := s.db.Query(`SELECT DISTINCT target FROM probes WHERE `+where+` ORDER BY target`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []string{}
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// Range returns the summaries in the time range. If step is positive,
+// summaries of a target, module and labels are averaged into buckets of
+// that width, timestamped with the start of the bucket. Retransmits are
+// summed and RTT minimum and maximum are taken over the bucket.
+func (s *Store) Range(q Query, step time.Duration) ([]*Summary, error) {
+	where, args := q.where()
+	bucket := `time`
+	if step > 0 {
+		bucket = fmt.Sprintf(`(time / %d) * %d`, step.Nanoseconds(), step.Nanoseconds())
+	}
+	rows, err := s.db.Query(`SELECT `+bucket+` AS bucket, target, module, labels,
+		AVG(duration_seconds), AVG(sent_bits_per_second), AVG(received_bits_per_second), MAX(reverse),
+		MIN(rtt_min_seconds), AVG(rtt_mean_seconds), MAX(rtt_max_seconds), CAST(SUM(retransmits) AS INTEGER),
+		AVG(lost_percent), AVG(jitter_seconds), AVG(cpu_host_percent), AVG(cpu_remote_percent)
+		FROM probes WHERE `+where+`
+		GROUP BY bucket, target, module, labels ORDER BY bucket, target, module, labels`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*Summary{}
+	for rows.Next() {
+		var nanos int64
+		var labels string
+		summary := &Summary{}
+		if err := rows.Scan(&nanos, &summary.Target, &summary.Module, &labels,
+			&summary.DurationSeconds, &summary.SentBitsPerSecond, &summary.ReceivedBitsPerSecond, &summary.Reverse,
+			&summary.RTTMinSeconds, &summary.RTTMeanSeconds, &summary.RTTMaxSeconds, &summary.Retransmits,
+			&summary.LostPercent, &summary.JitterSeconds, &summary.CPUHostPercent, &summary.CPURemotePercent); err != nil {
+			return nil, err
+		}
+		if summary.Labels, err = decodeLabels(labels); err != nil {
+			return nil, err
+		}
+		summary.Time = time.Unix(0, nanos).UTC()
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// Percentiles are the requested quantiles of the fields of a series, keyed
+// by field name and formatted quantile.
+type Percentiles map[string]map[string]float64
+
+// SeriesPercentiles are the percentiles of the probes of a target and module
+// with the same labels.
+type SeriesPercentiles struct {
+	Target      string            `json:"target"`
+	Module      string            `json:"module"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Percentiles Percentiles       `json:"percentiles"`
+}
+
+var percentileFields = []string{
+	"sent_bits_per_second",
+	"received_bits_per_second",
+	"rtt_mean_seconds",
+	"retransmits",
+	"lost_percent",
+	"jitter_seconds",
+}
+
+// Percentiles computes the quantiles of the summary fields per target,
+// module and labels within the time range, using linear interpolation
+// between closest ranks. Probes of different modules or labels, e.g. IPv4
+// and IPv6 tests, are kept apart, as they are not comparable.
+func (s *Store) Percentiles(q Query, quantiles []float64) ([]*SeriesPercentiles, error) {
+	where, args := q.where()
+	rows, err := s.db.Query(`SELECT target, module, labels, sent_bits_per_second, received_bits_per_second,
+		rtt_mean_seconds, retransmits, lost_percent, jitter_seconds
+		FROM probes WHERE `+where+` ORDER BY target, module, labels`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct{ target, module, labels string }
+	var keys []key
+	values := map[key]map[string][]float64{}
+	for rows.Next() {
+		var k key
+		fields := make([]sql.NullFloat64, len(percentileFields))
+		dest := []interface{}{&k.target, &k.module, &k.labels}
+		for i := range fields {
+			dest = append(dest, &fields[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if values[k] == nil {
+			keys = append(keys, k)
+			values[k] = map[string][]float64{}
+		}
+		for i, field := range fields {
+			if field.Valid {
+				values[k][percentileFields[i]] = append(values[k][percentileFields[i]], field.Float64)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []*SeriesPercentiles{}
+	for _, k := range keys {
+		labels, err := decodeLabels(k.labels)
+		if err != nil {
+			return nil, err
+		}
+		series := &SeriesPercentiles{Target: k.target, Module: k.module, Labels: labels, Percentiles: Percentiles{}}
+		for field, samples := range values[k] {
+			sort.Float64s(samples)
+			series.Percentiles[field] = map[string]float64{}
+			for _, quantile := range quantiles {
+				series.Percentiles[field][fmt.Sprint(quantile)] = quantileOf(samples, quantile)
+			}
+		}
+		result = append(result, series)
+	}
+	return result, nil
+}
+
+func quantileOf(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
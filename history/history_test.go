@@ -0,0 +1,125 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore returns a store with the summaries, one second apart.
+func testStore(t *testing.T, summaries []*Summary) (*Store, Query) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	now := time.Now()
+	for i, summary := range summaries {
+		summary.Time = now.Add(time.Duration(i) * time.Second)
+		if err := store.Insert(summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, Query{From: now.Add(-time.Minute), To: now.Add(time.Minute)}
+}
+
+func TestPercentiles(t *testing.T) {
+	ip4 := map[string]string{"ip_version": "4"}
+	ip6 := map[string]string{"ip_version": "6"}
+	store, query := testStore(t, []*Summary{
+		{Target: "a", Module: "download", Labels: ip4, ReceivedBitsPerSecond: 100},
+		{Target: "a", Module: "download", Labels: ip6, ReceivedBitsPerSecond: 1000},
+		{Target: "a", Module: "download", Labels: ip4, ReceivedBitsPerSecond: 200},
+		{Target: "a", Module: "download", Labels: ip6, ReceivedBitsPerSecond: 2000},
+		{Target: "a", Module: "download", Labels: ip4, ReceivedBitsPerSecond: 300},
+		{Target: "a", Module: "upload", ReceivedBitsPerSecond: 10},
+		{Target: "b", Module: "download", ReceivedBitsPerSecond: 50},
+	})
+
+	percentiles, err := store.Percentiles(query, []float64{0.5, 0.75})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type series struct {
+		target, module string
+		labels         map[string]string
+		received       map[string]float64
+	}
+	var got []series
+	for _, p := range percentiles {
+		got = append(got, series{p.Target, p.Module, p.Labels, p.Percentiles["received_bits_per_second"]})
+	}
+	want := []series{
+		{"a", "download", ip4, map[string]float64{"0.5": 200, "0.75": 250}},
+		{"a", "download", ip6, map[string]float64{"0.5": 1500, "0.75": 1750}},
+		{"a", "upload", nil, map[string]float64{"0.5": 10, "0.75": 10}},
+		{"b", "download", nil, map[string]float64{"0.5": 50, "0.75": 50}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received_bits_per_second percentiles = %v, want %v", got, want)
+	}
+
+	query.Module = "upload"
+	percentiles, err = store.Percentiles(query, []float64{0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(percentiles) != 1 || percentiles[0].Target != "a" || percentiles[0].Module != "upload" {
+		t.Errorf("percentiles of module upload = %v, want those of target a only", percentiles)
+	}
+}
+
+func TestRangeLabels(t *testing.T) {
+	ip4 := map[string]string{"ip_version": "4"}
+	ip6 := map[string]string{"ip_version": "6"}
+	store, query := testStore(t, []*Summary{
+		{Target: "a", Module: "download", Labels: ip4, SentBitsPerSecond: 100},
+		{Target: "a", Module: "download", Labels: ip6, SentBitsPerSecond: 1000},
+		{Target: "a", Module: "download", Labels: ip4, SentBitsPerSecond: 300},
+	})
+
+	summaries, err := store.Range(query, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d summaries, want one per label set", len(summaries))
+	}
+	for _, summary := range summaries {
+		want := map[string]float64{"4": 200, "6": 1000}[summary.Labels["ip_version"]]
+		if summary.SentBitsPerSecond != want {
+			t.Errorf("average of %v = %g, want %g", summary.Labels, summary.SentBitsPerSecond, want)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The table as created before probes were told apart by labels.
+	if _, err := db.Exec(`CREATE TABLE probes (
+		time INTEGER NOT NULL, target TEXT NOT NULL, module TEXT NOT NULL,
+		duration_seconds REAL NOT NULL, sent_bits_per_second REAL NOT NULL, received_bits_per_second REAL NOT NULL,
+		reverse INTEGER NOT NULL, rtt_min_seconds REAL, rtt_mean_seconds REAL, rtt_max_seconds REAL,
+		retransmits INTEGER NOT NULL, lost_percent REAL, jitter_seconds REAL,
+		cpu_host_percent REAL NOT NULL, cpu_remote_percent REAL NOT NULL
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Insert(&Summary{Time: time.Now(), Target: "a", Module: "m", Labels: map[string]string{"dscp": "ef"}}); err != nil {
+		t.Fatalf("inserting into a migrated database: %s", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package dnstest provides a DNS server for tests, answering A, AAAA and
+// SRV queries from a fixed set of records.
+package dnstest
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	typeSRV  = 33
+)
+
+// Zone holds the records the server answers with, by fully qualified name,
+// e.g. "iperf3.test.".
+type Zone struct {
+	A    map[string][]string
+	AAAA map[string][]string
+	SRV  map[string][]net.SRV
+}
+
+// Server is a DNS server listening on UDP on the loopback interface.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr    string
+	zone    Zone
+	queries int32
+}
+
+// NewServer starts a server answering from zone, which is stopped when the
+// test finishes. Queries for names or types without records are answered
+// with no records.
+func NewServer(t testing.TB, zone Zone) *Server {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &Server{Addr: conn.LocalAddr().String(), zone: zone}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := s.answer(buf[:n]); response != nil {
+				atomic.AddInt32(&s.queries, 1)
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return s
+}
+
+// Queries returns the number of queries answered.
+func (s *Server) Queries() int {
+	return int(atomic.LoadInt32(&s.queries))
+}
+
+// answer returns the response to query, or nil if it is malformed.
+func (s *Server) answer(query []byte) []byte {
+	// The question follows the 12 byte header: the name as labels ending
+	// with an empty one, then type and class.
+	var labels []string
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		length := int(query[end])
+		if end+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[end+1:end+1+length]))
+		end += length + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+	var answers [][]byte
+	switch binary.BigEndian.Uint16(query[end-4:]) {
+	case typeA:
+		for _, ip := range s.zone.A[name] {
+			answers = append(answers, record(typeA, net.ParseIP(ip).To4()))
+		}
+	case typeAAAA:
+		for _, ip := range s.zone.AAAA[name] {
+			answers = append(answers, record(typeAAAA, net.ParseIP(ip).To16()))
+		}
+	case typeSRV:
+		for _, srv := range s.zone.SRV[name] {
+			data := make([]byte, 6)
+			binary.BigEndian.PutUint16(data, srv.Priority)
+			binary.BigEndian.PutUint16(data[2:], srv.Weight)
+			binary.BigEndian.PutUint16(data[4:], srv.Port)
+			answers = append(answers, record(typeSRV, append(data, encodeName(srv.Target)...)))
+		}
+	}
+
+	response := append([]byte{}, query[:end]...)
+	binary.BigEndian.PutUint16(response[2:], 0x8180)
+	binary.BigEndian.PutUint16(response[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(response[8:], 0)
+	binary.BigEndian.PutUint16(response[10:], 0)
+	for _, answer := range answers {
+		response = append(response, answer...)
+	}
+	return response
+}
+
+// record returns an answer for the name in the question, of class IN with a
+// TTL of 60s.
+func record(recordType uint16, data []byte) []byte {
+	answer := []byte{0xc0, 12, byte(recordType >> 8), byte(recordType), 0, 1, 0, 0, 0, 60, 0, 0}
+	binary.BigEndian.PutUint16(answer[10:], uint16(len(data)))
+	return append(answer, data...)
+}
+
+// encodeName returns name in the wire format, without compression.
+func encodeName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label != "" {
+			encoded = append(encoded, byte(len(label)))
+			encoded = append(encoded, label...)
+		}
+	}
+	return append(encoded, 0)
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/fluepke/iperf3-exporter/resolver"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -15,19 +22,63 @@ const version = "1.0.0"
 const namespace = "iperf3"
 
 var (
-	listenAddress      = flag.String("web.listen-address", ":9579", "Address to listen on for web interface and telemetry")
-	logLevel           = flag.String("log.level", "info", "Logging level")
-	iperf3Timeout      = flag.Duration("iperf3.timeout", 30*time.Second, "iperf3 timeout")
-	iperf3Path         = flag.String("iperf3.path", "iperf3", "iper3 binary path")
-	iperf3Duration     = flag.Duration("iperf3.time", 10*time.Second, "time in seconds to transmit for")
-	iperf3OmitDuration = flag.Duration("iper3.omitTime", 5*time.Second, "Omit the first  n  seconds  of the test, to skip past the TCP slow-start period")
-	iperf3Mss          = flag.Int("iperf3.mss", 1400, "Set TCP/SCTP maximum segment size (MTU - 40 bytes)")
-	iperf3Reverse      = flag.Bool("iperf3.reverse", false, "Reverse the direction of a test, so that the server sends data to the client")
+	listenAddress              = flag.String("web.listen-address", ":9579", "Address to listen on for web interface and telemetry")
+	logLevel                   = flag.String("log.level", "info", "Logging level")
+	iperf3Timeout              = flag.Duration("iperf3.timeout", 30*time.Second, "iperf3 timeout")
+	iperf3Path                 = flag.String("iperf3.path", "iperf3", "iper3 binary path")
+	iperf3Duration             = flag.Duration("iperf3.time", 10*time.Second, "time in seconds to transmit for")
+	iperf3OmitDuration         = flag.Duration("iper3.omitTime", 5*time.Second, "Omit the first  n  seconds  of the test, to skip past the TCP slow-start period")
+	iperf3Mss                  = flag.Int("iperf3.mss", 1400, "Set TCP/SCTP maximum segment size (MTU - 40 bytes)")
+	iperf3Window               = flag.Int("iperf3.window", 0, "Set the socket buffer sizes in bytes (0 leaves them to the kernel)")
+	iperf3Reverse              = flag.Bool("iperf3.reverse", false, "Reverse the direction of a test, so that the server sends data to the client")
+	iperf3JSONStream           = flag.Bool("iperf3.json-stream", false, "Use iperf3 --json-stream to parse results incrementally (requires iperf3 >= 3.17)")
+	archiveDir                 = flag.String("archive.dir", "", "Directory to archive the raw results of every probe to (disabled if empty)")
+	archiveMaxFileSize         = flag.Int64("archive.max-file-size", 64*1024*1024, "Size in bytes after which the archive file is rotated")
+	archiveMaxFileAge          = flag.Duration("archive.max-file-age", 24*time.Hour, "Age after which the archive file is rotated")
+	archiveRetention           = flag.Duration("archive.retention", 30*24*time.Hour, "Age after which archive files are deleted (0 keeps them forever)")
+	archiveMaxSize             = flag.Int64("archive.max-total-size", 0, "Total size in bytes of archive files to keep (0 is unlimited)")
+	historyPath                = flag.String("history.path", "", "Path to a SQLite database to keep probe summaries in (disabled if empty)")
+	historyRetention           = flag.Duration("history.retention", 365*24*time.Hour, "Age after which probe summaries are deleted (0 keeps them forever)")
+	influxDBURL                = flag.String("influxdb.url", "", "InfluxDB write endpoint to push results to in line protocol, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b (disabled if empty)")
+	influxDBToken              = flag.String("influxdb.token", "", "InfluxDB API token")
+	openTSDBURL                = flag.String("opentsdb.url", "", "OpenTSDB put endpoint to push results to, e.g. http://localhost:4242/api/put (disabled if empty)")
+	otlpEndpoint               = flag.String("otlp.endpoint", "", "OTLP endpoint to export results as OpenTelemetry metrics to, host:port for grpc or a URL like http://localhost:4318/v1/metrics for http/protobuf (disabled if empty)")
+	otlpProtocol               = flag.String("otlp.protocol", "grpc", "OTLP protocol, grpc or http/protobuf")
+	otlpInsecure               = flag.Bool("otlp.insecure", false, "Disable TLS for OTLP over grpc")
+	otlpHeaders                = flag.String("otlp.headers", "", "Comma separated key=value pairs sent as headers with OTLP exports")
+	outputBatchSize            = positiveIntFlag("output.batch-size", 1000, "Number of points per push to InfluxDB or OpenTSDB")
+	outputFlushInterval        = positiveDurationFlag("output.flush-interval", 10*time.Second, "Maximum time points are buffered before being pushed")
+	outputMaxBuffered          = flag.Int("output.max-buffered", 100000, "Maximum number of points buffered while a push endpoint is unreachable")
+	outputMaxRetries           = flag.Int("output.max-retries", 5, "Retries of a failed push before the points are kept for the next flush")
+	outputTimeout              = flag.Duration("output.timeout", 10*time.Second, "Timeout of a single push")
+	remoteWriteURL             = flag.String("remote-write.url", "", "Prometheus remote write endpoint to push the results of scheduled probes to (disabled if empty)")
+	remoteWriteJob             = flag.String("remote-write.job", "iperf3", "Value of the job label of pushed samples")
+	remoteWriteWALDir          = flag.String("remote-write.wal-dir", "", "Directory to keep pending remote write requests in across outages and restarts (in memory if empty)")
+	remoteWriteBearerTokenFile = flag.String("remote-write.bearer-token-file", "", "File containing the bearer token for remote write")
+	remoteWriteUsername        = flag.String("remote-write.username", "", "Basic auth username for remote write")
+	remoteWritePasswordFile    = flag.String("remote-write.password-file", "", "File containing the basic auth password for remote write")
+	remoteWriteMaxPending      = flag.Int("remote-write.max-pending", 10000, "Maximum number of pending remote write requests (0 is unlimited)")
+	remoteWriteMaxAge          = flag.Duration("remote-write.max-age", 24*time.Hour, "Age after which pending remote write requests are dropped (0 keeps them forever)")
+	remoteWriteTimeout         = flag.Duration("remote-write.timeout", 30*time.Second, "Timeout of a remote write request")
+	tracingEndpoint            = flag.String("tracing.endpoint", "", "OTLP endpoint to export probe traces to, host:port for grpc or a URL like http://localhost:4318/v1/traces for http/protobuf (disabled if empty)")
+	tracingProtocol            = flag.String("tracing.protocol", "grpc", "OTLP protocol for traces, grpc or http/protobuf")
+	tracingInsecure            = flag.Bool("tracing.insecure", false, "Disable TLS for trace export over grpc")
+	tracingHeaders             = flag.String("tracing.headers", "", "Comma separated key=value pairs sent as headers with trace exports")
+	tracingSampleRatio         = flag.Float64("tracing.sample-ratio", 1, "Ratio of probes to trace unless the caller already decided")
+	iperf3MaxConcurrent        = flag.Int("iperf3.max-concurrent", 0, "Maximum number of iperf3 tests running at the same time, further probes are queued (0 is unlimited)")
+	schedulerConcurrency       = flag.Int("scheduler.concurrency", 1, "Maximum number of scheduled probes running at the same time")
+	dnsServer                  = flag.String("dns.server", "", "DNS server (host:port) to resolve targets of modules with resolve set or idle_latency (system resolver if empty)")
+	budgetStateFile            = flag.String("budget.state-file", "", "File to keep the traffic accounted against budgets in across restarts (in memory if empty)")
+	metricsMaxTargets          = flag.Int("metrics.max-targets", 1000, "Maximum number of target and module pairs with their own exporter metrics, further ones are counted as "+overflowTarget+" (0 is unlimited)")
+	metricsTargetExpiry        = flag.Duration("metrics.target-expiry", 24*time.Hour, "Time after which the exporter metrics of a target and module not probed anymore are removed (0 keeps them forever)")
+	configFile                 = flag.String("config.file", "", "Path to a YAML file defining probe modules")
+
+	conf       *config.Config
+	sinks      []collector.Sink
+	probeQueue chan struct{}
 
 	iperf3DurationSummary = prometheus.NewSummary(prometheus.SummaryOpts{Name: prometheus.BuildFQName(namespace, "exporter", "duration_seconds"), Help: "Duration of collections by the iperf3 exporter."})
-	iperf3Errors          = prometheus.NewCounter(prometheus.CounterOpts{Name: prometheus.BuildFQName(namespace, "exporter", "errors_total"), Help: "Errors raised by the iperf3 exporter."})
-	iperf3BytesSent       = prometheus.NewGauge(prometheus.GaugeOpts{Name: prometheus.BuildFQName(namespace, "exporter", "sent_bytes"), Help: "Total bytes sent by iperf3."})
-	iperf3BytesReceived   = prometheus.NewGauge(prometheus.GaugeOpts{Name: prometheus.BuildFQName(namespace, "exporter", "received_bytes"), Help: "Total bytes received by iperf3."})
+	exporterMetrics       *targetMetrics
 )
 
 func main() {
@@ -38,40 +89,127 @@ func main() {
 	}
 	log.SetLevel(level)
 
+	if flag.Arg(0) == "replay" {
+		os.Exit(runReplay(flag.Args()[1:]))
+	}
+
 	log.WithFields(log.Fields{
 		"author":  "@fluepke",
 		"version": version,
 	}).Info("Starting iperf3-exporter")
 
 	prometheus.MustRegister(iperf3DurationSummary)
-	prometheus.MustRegister(iperf3Errors)
-	prometheus.MustRegister(iperf3BytesSent)
-	prometheus.MustRegister(iperf3BytesReceived)
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-            <head><title>iperf3-exporter</title></head>
-			<body>
-			<h1>iperf3-exporter</h1>
-			<p>` + version + `</p>
-			<form action="/probe" method="GET">
-			<input type="text" name="target" value="target" />
-			<input type="text" name="duration" value="5s" />
-			</form>
-			</body>
-			</html>`))
-	})
+	exporterMetrics = newTargetMetrics(*metricsMaxTargets, *metricsTargetExpiry)
+	prometheus.MustRegister(exporterMetrics)
+
+	conf, err = loadConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Could not load configuration")
+	}
+
+	if *iperf3MaxConcurrent > 0 {
+		probeQueue = make(chan struct{}, *iperf3MaxConcurrent)
+	}
+	setupTracing()
+	targetResolver = resolver.New(*dnsServer)
+	setupSinks()
+	setupBudgets()
 
+	if flag.Arg(0) == "push" {
+		code := runPush(flag.Args()[1:])
+		closeSinks()
+		os.Exit(code)
+	}
+
+	startScheduler()
+
+	registerUI()
 	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/probe", handleProbeRequest)
+	http.HandleFunc("/probe", traced("probe", handleProbeRequest))
+	http.HandleFunc("/probe/json", traced("probe json", handleProbeJSONRequest))
+	http.HandleFunc("/probe/stream", traced("probe stream", handleProbeStreamRequest))
 	log.WithFields(log.Fields{
 		"listenAddress": *listenAddress,
 	}).Info("Starting to listen")
 
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	server := &http.Server{Addr: *listenAddress}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Info("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), *iperf3Timeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	stopScheduler()
+	closeSinks()
+}
+
+func loadConfig() (*config.Config, error) {
+	defaults := config.Module{
+		Timeout:      *iperf3Timeout,
+		Duration:     *iperf3Duration,
+		OmitDuration: *iperf3OmitDuration,
+		MSS:          *iperf3Mss,
+		Window:       *iperf3Window,
+		Reverse:      *iperf3Reverse,
+		JSONStream:   *iperf3JSONStream,
+	}
+	if *configFile == "" {
+		return config.Load(nil, defaults)
+	}
+	return config.LoadFile(*configFile, defaults)
 }
 
 func handleProbeRequest(w http.ResponseWriter, request *http.Request) {
+	iperf3Collector, ok := newCollector(w, request)
+	if !ok {
+		return
+	}
+	iperf3Collector.Context = request.Context()
+	if timeout, err := strconv.ParseFloat(request.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+		if longest := iperf3Collector.module.MaxProbeDuration(); longest.Seconds() > timeout {
+			log.WithFields(log.Fields{
+				"target":         iperf3Collector.Target,
+				"module":         iperf3Collector.Module,
+				"scrape_timeout": timeout,
+				"longest":        longest,
+			}).Warn("Scrape timeout is shorter than the longest duration of the probe")
+		}
+	}
+
+	start := time.Now()
+	// The addresses of a resolved target are probed one after another, as
+	// Gatherers gathers sequentially.
+	gatherers := prometheus.Gatherers{}
+	collectors, err := iperf3Collector.expand(request.Context())
+	if err != nil {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&collector.ResultsCollector{})
+		gatherers = append(gatherers, registry)
+	}
+	for _, c := range collectors {
+		registry := prometheus.NewRegistry()
+		prometheus.WrapRegistererWith(probeLabels(c), registry).MustRegister(c)
+		gatherers = append(gatherers, registry)
+	}
+	h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+	h.ServeHTTP(w, request)
+
+	iperf3DurationSummary.Observe(time.Since(start).Seconds())
+}
+
+// newCollector builds a collector from the requested module and query
+// parameter overrides. On invalid input an error response is written and
+// false is returned.
+func newCollector(w http.ResponseWriter, request *http.Request) (*probe, bool) {
 	logger := log.WithFields(log.Fields{
 		"uri":         request.RequestURI,
 		"remote_addr": request.RemoteAddr,
@@ -82,79 +220,222 @@ func handleProbeRequest(w http.ResponseWriter, request *http.Request) {
 	target := request.URL.Query().Get("target")
 	if target == "" {
 		http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
-		iperf3Errors.Inc()
+		exporterMetrics.requestError()
 		logger.Error("Target was not specified")
-		return
+		return nil, false
+	}
+
+	moduleName := request.URL.Query().Get("module")
+	if moduleName == "" {
+		moduleName = config.DefaultModule
+	}
+	module, ok := conf.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		exporterMetrics.error(target, moduleName)
+		logger.WithFields(log.Fields{"module": moduleName}).Error("Unknown module")
+		return nil, false
 	}
 
 	var err error
 	duration := request.URL.Query().Get("duration")
-	testDuration := *iperf3Duration
+	testDuration := module.Duration
 	if duration != "" {
 		testDuration, err = time.ParseDuration(duration)
 		if err != nil {
 			http.Error(w, "'duration' parameter must be duration", http.StatusBadRequest)
-			iperf3Errors.Inc()
+			exporterMetrics.error(target, moduleName)
 			logger.Error("'duration' parameter could not be parsed as duration")
-			return
+			return nil, false
 		}
 	}
 
 	omitDuration := request.URL.Query().Get("omit-duration")
-	testOmitDuration := *iperf3OmitDuration
+	testOmitDuration := module.OmitDuration
 	if omitDuration != "" {
 		testOmitDuration, err = time.ParseDuration(omitDuration)
 		if err != nil {
 			http.Error(w, "'omit-duration' parameter must be duration", http.StatusBadRequest)
-			iperf3Errors.Inc()
+			exporterMetrics.error(target, moduleName)
 			logger.Error("'omit-duration' parameter could not be parsed as duration")
-			return
+			return nil, false
 		}
 	}
 
 	mss := request.URL.Query().Get("mss")
-	testMss := *iperf3Mss
+	testMss := module.MSS
 	if mss != "" {
 		testMss, err = strconv.Atoi(mss)
 		if err != nil || testMss < 535 {
 			http.Error(w, "'mss' parameter must be integer > 535", http.StatusBadRequest)
-			iperf3Errors.Inc()
+			exporterMetrics.error(target, moduleName)
 			logger.Error("'mss' parameter must be integer > 535")
-			return
+			return nil, false
+		}
+	}
+
+	window := request.URL.Query().Get("window")
+	testWindow := module.Window
+	if window != "" {
+		testWindow, err = strconv.Atoi(window)
+		if err != nil || testWindow < 0 {
+			http.Error(w, "'window' parameter must be a non-negative integer", http.StatusBadRequest)
+			exporterMetrics.error(target, moduleName)
+			logger.Error("'window' parameter must be a non-negative integer")
+			return nil, false
 		}
 	}
 
 	reverse := request.URL.Query().Get("reverse")
-	testReverse := *iperf3Reverse
+	testReverse := module.Reverse
 	if reverse != "" {
 		testReverse, err = strconv.ParseBool(reverse)
 		if err != nil {
 			http.Error(w, "'reverse' parameter must be bool", http.StatusBadRequest)
-			iperf3Errors.Inc()
+			exporterMetrics.error(target, moduleName)
 			logger.Error("'reverse' paramter could not be parsed as bool")
-			return
+			return nil, false
 		}
 	}
 
-	iperf3Collector := &collector.Collector{
-		Timeout:      *iperf3Timeout,
-		Iperf3Path:   *iperf3Path,
-		Target:       target,
-		Duration:     testDuration,
-		OmitDuration: testOmitDuration,
-		MSS:          testMss,
-		Reverse:      testReverse,
+	ipProtocol := request.URL.Query().Get("ip-protocol")
+	testIPProtocol := module.IPProtocol
+	testDualStack := module.DualStack
+	if ipProtocol != "" {
+		if ipProtocol != config.IPProtocol4 && ipProtocol != config.IPProtocol6 && ipProtocol != config.IPProtocolPrefer {
+			http.Error(w, "'ip-protocol' parameter must be ip4, ip6 or prefer", http.StatusBadRequest)
+			exporterMetrics.error(target, moduleName)
+			logger.Error("'ip-protocol' parameter must be ip4, ip6 or prefer")
+			return nil, false
+		}
+		testIPProtocol = ipProtocol
+		testDualStack = false
+	}
 
-		ErrorCounter: iperf3Errors,
-		RxCounter:    iperf3BytesReceived,
-		TxCounter:    iperf3BytesSent,
+	dscp := request.URL.Query().Get("dscp")
+	testDSCP := module.DSCP
+	testTOS := module.TOS
+	testQoSClasses := module.QoSClasses
+	if dscp != "" {
+		if _, err := config.ParseDSCP(dscp); err != nil {
+			http.Error(w, "'dscp' parameter must be a DSCP class or integer between 0 and 63", http.StatusBadRequest)
+			exporterMetrics.error(target, moduleName)
+			logger.Error("'dscp' parameter must be a DSCP class or integer between 0 and 63")
+			return nil, false
+		}
+		testDSCP = dscp
+		testTOS = 0
+		testQoSClasses = nil
 	}
 
-	start := time.Now()
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(iperf3Collector)
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, request)
+	congestionControl := request.URL.Query().Get("congestion-control")
+	testCongestionControl := module.CongestionControl
+	testCongestionControls := module.CongestionControls
+	if congestionControl != "" {
+		if module.UDP {
+			http.Error(w, "'congestion-control' parameter is only valid with TCP modules", http.StatusBadRequest)
+			exporterMetrics.error(target, moduleName)
+			logger.Error("'congestion-control' parameter is only valid with TCP modules")
+			return nil, false
+		}
+		if err := config.ValidateCongestionControl(congestionControl); err != nil {
+			http.Error(w, "'congestion-control' parameter must be a congestion control algorithm", http.StatusBadRequest)
+			exporterMetrics.error(target, moduleName)
+			logger.Error("'congestion-control' parameter must be a congestion control algorithm")
+			return nil, false
+		}
+		testCongestionControl = congestionControl
+		testCongestionControls = nil
+	}
 
-	iperf3DurationSummary.Observe(time.Since(start).Seconds())
+	probeModule := *module
+	probeModule.Duration = testDuration
+	probeModule.OmitDuration = testOmitDuration
+	probeModule.MSS = testMss
+	probeModule.Window = testWindow
+	probeModule.Reverse = testReverse
+	probeModule.IPProtocol = testIPProtocol
+	probeModule.DualStack = testDualStack
+	probeModule.DSCP = testDSCP
+	probeModule.TOS = testTOS
+	probeModule.QoSClasses = testQoSClasses
+	probeModule.CongestionControl = testCongestionControl
+	probeModule.CongestionControls = testCongestionControls
+	if err := probeModule.ValidateBindAddressFamily(); err != nil {
+		http.Error(w, "'ip-protocol' parameter does not match the bind address of the module", http.StatusBadRequest)
+		exporterMetrics.error(target, moduleName)
+		logger.WithFields(log.Fields{"err": err}).Error("'ip-protocol' parameter does not match the bind address of the module")
+		return nil, false
+	}
+	return newModuleCollector(target, moduleName, &probeModule), true
+}
+
+// probe is a collector along with the module it was built from, whose
+// resolution and address family settings the exporter applies around it.
+type probe struct {
+	*collector.Collector
+	module *config.Module
+}
+
+func newModuleCollector(target string, moduleName string, module *config.Module) *probe {
+	moduleName, module = downgrade(target, moduleName, module)
+	iperf3Collector := &collector.Collector{
+		Timeout:           module.ProbeTimeout(),
+		Iperf3Path:        *iperf3Path,
+		Target:            target,
+		Module:            moduleName,
+		Duration:          module.Duration,
+		OmitDuration:      module.OmitDuration,
+		MSS:               module.MSS,
+		Window:            module.Window,
+		Bytes:             module.Bytes,
+		Blocks:            module.Blocks,
+		UDP:               module.UDP,
+		Bitrate:           module.Bitrate,
+		IdleLatency:       module.IdleLatency,
+		IdleLatencyPort:   module.IdleLatencyPort,
+		DNSServer:         *dnsServer,
+		Reverse:           module.Reverse,
+		JSONStream:        module.JSONStream,
+		Port:              module.Port,
+		BindAddress:       module.BindAddress,
+		BindDevice:        module.Interface,
+		ClientPort:        module.ClientPort,
+		Netns:             module.NetnsPath(),
+		TOS:               module.TOSByte(),
+		CongestionControl: module.CongestionControl,
+		Sinks:             sinks,
+		Queue:             probeQueue,
+	}
+	if baseline := module.RFC6349; baseline != nil {
+		iperf3Collector.RFC6349 = &collector.RFC6349{
+			BaselineRTT:         baseline.BaselineRTT,
+			BottleneckBandwidth: baseline.BottleneckBandwidth,
+		}
+	}
+	if sweep := module.MSSSweep; sweep != nil {
+		iperf3Collector.MSSSweep = &collector.MSSSweep{
+			MSS:            sweep.MSS,
+			FullThroughput: sweep.FullThroughput,
+		}
+	}
+	if len(module.StreamSweep) > 0 {
+		iperf3Collector.StreamSweep = module.StreamSweep
+	}
+	if search := module.UDPSearch; search != nil {
+		iperf3Collector.Search = &collector.Search{
+			Method:        search.Method,
+			MinBitrate:    search.MinBitrate,
+			MaxBitrate:    search.MaxBitrate,
+			Step:          search.Step,
+			Precision:     search.Precision,
+			LossThreshold: search.LossThreshold,
+		}
+	}
+	if budgets != nil {
+		iperf3Collector.Admit = func() error { return admit(target) }
+		iperf3Collector.Account = budgets.Write
+	}
+	exporterMetrics.instrument(iperf3Collector)
+	return &probe{Collector: iperf3Collector, module: module}
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCollectorOverrides(t *testing.T) {
+	defer func(c *config.Config, m *targetMetrics) { conf, exporterMetrics = c, m }(conf, exporterMetrics)
+	conf = &config.Config{Modules: map[string]*config.Module{
+		"tcp":   {},
+		"udp":   {UDP: true},
+		"bound": {BindAddress: "192.0.2.1"},
+	}}
+	exporterMetrics = newTargetMetrics(0, 0)
+
+	for _, test := range []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"congestion control", "module=tcp&congestion-control=bbr", http.StatusOK},
+		{"congestion control of udp", "module=udp&congestion-control=bbr", http.StatusBadRequest},
+		{"ip protocol of bind address", "module=bound&ip-protocol=ip4", http.StatusOK},
+		{"ip protocol other than bind address", "module=bound&ip-protocol=ip6", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		_, ok := newCollector(w, httptest.NewRequest("GET", "/probe?target=192.0.2.1&"+test.query, nil))
+		if ok != (test.status == http.StatusOK) || w.Code != test.status {
+			t.Errorf("%s: got %t and status %d, want status %d", test.name, ok, w.Code, test.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if _, ok := newCollector(w, httptest.NewRequest("GET", "/probe?module=tcp", nil)); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("without target: got %t and status %d, want status 400", ok, w.Code)
+	}
+	if got := testutil.ToFloat64(exporterMetrics.requestErrors); got != 1 {
+		t.Errorf("request errors = %g, want 1", got)
+	}
+	if _, ok := exporterMetrics.lastSeen[[2]string{"", ""}]; ok {
+		t.Error("got exporter metrics with an empty target")
+	}
+}
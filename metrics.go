@@ -0,0 +1,171 @@
+package main
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// overflowTarget replaces target and module of series beyond the cardinality
+// limit.
+const overflowTarget = "__overflow__"
+
+// targetMetrics are the exporter's own metrics per target and module. At most
+// maxTargets pairs are tracked, further ones are counted as overflowTarget.
+// Pairs not probed within expiry are removed.
+type targetMetrics struct {
+	maxTargets int
+	expiry     time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[[2]string]time.Time
+
+	errors *prometheus.CounterVec
+	// requestErrors counts errors of requests without a target, which have
+	// no series in errors.
+	requestErrors prometheus.Counter
+	sent          *prometheus.CounterVec
+	received      *prometheus.CounterVec
+	probes        *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+}
+
+func newTargetMetrics(maxTargets int, expiry time.Duration) *targetMetrics {
+	labels := []string{"target", "module"}
+	return &targetMetrics{
+		maxTargets: maxTargets,
+		expiry:     expiry,
+		lastSeen:   map[[2]string]time.Time{},
+
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "exporter", "errors_total"),
+			Help: "Errors raised by the iperf3 exporter.",
+		}, labels),
+		requestErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "exporter", "request_errors_total"),
+			Help: "Requests to the iperf3 exporter rejected for lack of a target.",
+		}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "exporter", "sent_bytes_total"),
+			Help: "Total bytes sent by iperf3.",
+		}, labels),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "exporter", "received_bytes_total"),
+			Help: "Total bytes received by iperf3.",
+		}, labels),
+		probes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(namespace, "exporter", "probes_total"),
+			Help: "Probes run by the iperf3 exporter.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName(namespace, "exporter", "probe_duration_seconds"),
+			Help:    "Duration of probes run by the iperf3 exporter.",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 120, 300},
+		}, labels),
+	}
+}
+
+// labels returns the label values for target and module, marking them as
+// seen. m.mu must be held.
+func (m *targetMetrics) labels(target, module string) []string {
+	key := [2]string{target, module}
+	if _, ok := m.lastSeen[key]; !ok && m.maxTargets > 0 && len(m.lastSeen) >= m.maxTargets {
+		log.WithFields(log.Fields{
+			"target": target,
+			"module": module,
+			"limit":  m.maxTargets,
+		}).Warn("Too many targets, counting the exporter metrics of this one as overflow")
+		key = [2]string{overflowTarget, overflowTarget}
+	}
+	m.lastSeen[key] = time.Now()
+	return key[:]
+}
+
+// error counts an error of a probe that did not start, e.g. an invalid request.
+func (m *targetMetrics) error(target, module string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors.WithLabelValues(m.labels(target, module)...).Inc()
+}
+
+// requestError counts an error of a request without a target.
+func (m *targetMetrics) requestError() {
+	m.requestErrors.Inc()
+}
+
+// instrument points the counters of c at the series of its target and module.
+// The series are looked up at every increment, so a probe running while its
+// series expire recreates them instead of counting into deleted ones.
+func (m *targetMetrics) instrument(c *collector.Collector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	labels := m.labels(c.Target, c.Module)
+	c.ErrorCounter = &targetCounter{m.errors.WithLabelValues(labels...), m, m.errors, c.Target, c.Module}
+	c.TxCounter = &targetCounter{m.sent.WithLabelValues(labels...), m, m.sent, c.Target, c.Module}
+	c.RxCounter = &targetCounter{m.received.WithLabelValues(labels...), m, m.received, c.Target, c.Module}
+	c.ProbeCounter = &targetCounter{m.probes.WithLabelValues(labels...), m, m.probes, c.Target, c.Module}
+	target, module := c.Target, c.Module
+	c.DurationObserver = prometheus.ObserverFunc(func(value float64) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.duration.WithLabelValues(m.labels(target, module)...).Observe(value)
+	})
+}
+
+// targetCounter increments the current series of a target and module. The
+// embedded counter only provides the remaining methods of prometheus.Counter.
+type targetCounter struct {
+	prometheus.Counter
+	metrics        *targetMetrics
+	vec            *prometheus.CounterVec
+	target, module string
+}
+
+func (c *targetCounter) Inc() {
+	c.Add(1)
+}
+
+func (c *targetCounter) Add(value float64) {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	c.vec.WithLabelValues(c.metrics.labels(c.target, c.module)...).Add(value)
+}
+
+// expire removes the series of pairs not seen within expiry.
+func (m *targetMetrics) expire() {
+	if m.expiry <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, seen := range m.lastSeen {
+		if time.Since(seen) < m.expiry {
+			continue
+		}
+		delete(m.lastSeen, key)
+		for _, vec := range []*prometheus.MetricVec{m.errors.MetricVec, m.sent.MetricVec, m.received.MetricVec, m.probes.MetricVec, m.duration.MetricVec} {
+			vec.DeleteLabelValues(key[:]...)
+		}
+	}
+}
+
+func (m *targetMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.errors.Describe(ch)
+	m.requestErrors.Describe(ch)
+	m.sent.Describe(ch)
+	m.received.Describe(ch)
+	m.probes.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+func (m *targetMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.expire()
+	m.errors.Collect(ch)
+	m.requestErrors.Collect(ch)
+	m.sent.Collect(ch)
+	m.received.Collect(ch)
+	m.probes.Collect(ch)
+	m.duration.Collect(ch)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+func TestTargetMetricsExpire(t *testing.T) {
+	m := newTargetMetrics(0, time.Millisecond)
+	running := &collector.Collector{Target: "a", Module: "m"}
+	m.instrument(running)
+	idle := &collector.Collector{Target: "b", Module: "m"}
+	m.instrument(idle)
+	idle.ProbeCounter.Inc()
+
+	time.Sleep(5 * time.Millisecond)
+	if n := testutil.CollectAndCount(m.probes); n != 2 {
+		t.Fatalf("got %d probe series before expiry, want 2", n)
+	}
+	m.expire()
+	if n := testutil.CollectAndCount(m.probes); n != 0 {
+		t.Fatalf("got %d probe series after expiry, want 0", n)
+	}
+
+	// A probe still running when its series expired counts into new ones.
+	running.ProbeCounter.Inc()
+	running.TxCounter.Add(100)
+	running.DurationObserver.Observe(2)
+	if got := testutil.ToFloat64(m.probes.WithLabelValues("a", "m")); got != 1 {
+		t.Errorf("probes = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.sent.WithLabelValues("a", "m")); got != 100 {
+		t.Errorf("sent bytes = %g, want 100", got)
+	}
+	if n := testutil.CollectAndCount(m.duration); n != 1 {
+		t.Errorf("got %d duration series, want 1", n)
+	}
+	if _, ok := m.lastSeen[[2]string{"a", "m"}]; !ok {
+		t.Error("target a not marked as seen by the increment")
+	}
+}
+
+func TestTargetMetricsOverflow(t *testing.T) {
+	m := newTargetMetrics(1, 0)
+	for _, target := range []string{"a", "b", "c"} {
+		c := &collector.Collector{Target: target, Module: "m"}
+		m.instrument(c)
+		c.ProbeCounter.Inc()
+	}
+	m.error("d", "m")
+
+	if got := testutil.ToFloat64(m.probes.WithLabelValues("a", "m")); got != 1 {
+		t.Errorf("probes of a = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.probes.WithLabelValues(overflowTarget, overflowTarget)); got != 2 {
+		t.Errorf("overflow probes = %g, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.errors.WithLabelValues(overflowTarget, overflowTarget)); got != 1 {
+		t.Errorf("overflow errors = %g, want 1", got)
+	}
+}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"context"
+	"github.com/fluepke/iperf3-exporter/retry"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type BatchOptions struct {
+	// BatchSize is the number of entries that triggers a flush.
+	BatchSize int
+	// FlushInterval is the maximum time an entry is buffered.
+	FlushInterval time.Duration
+	// MaxBuffered bounds the entries kept while the endpoint is unreachable;
+	// the oldest entries are dropped beyond it.
+	MaxBuffered int
+	// MaxRetries and RetryBackoff control retries of a failed flush. The
+	// backoff doubles after every attempt.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Timeout      time.Duration
+}
+
+// batcher buffers encoded entries and sends them in batches from a
+// background goroutine, retrying failed sends with exponential backoff.
+type batcher struct {
+	name string
+	opts BatchOptions
+	send func(ctx context.Context, batch [][]byte) error
+
+	mu      sync.Mutex
+	buffer  [][]byte
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+func newBatcher(name string, opts BatchOptions, send func(context.Context, [][]byte) error) *batcher {
+	b := &batcher{
+		name:    name,
+		opts:    opts,
+		send:    send,
+		flushCh: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *batcher) add(entries ...[]byte) {
+	b.mu.Lock()
+	b.buffer = append(b.buffer, entries...)
+	b.trim()
+	full := len(b.buffer) >= b.opts.BatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest entries beyond MaxBuffered. b.mu must be held.
+func (b *batcher) trim() {
+	dropped := len(b.buffer) - b.opts.MaxBuffered
+	if b.opts.MaxBuffered <= 0 || dropped <= 0 {
+		return
+	}
+	b.buffer = b.buffer[dropped:]
+	log.WithFields(log.Fields{
+		"output":  b.name,
+		"dropped": dropped,
+	}).Warn("Output buffer full, dropping oldest entries")
+}
+
+func (b *batcher) loop() {
+	defer close(b.doneCh)
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.flushCh:
+		case <-b.stopCh:
+			b.flush()
+			return
+		}
+		b.flush()
+	}
+}
+
+func (b *batcher) flush() {
+	for {
+		b.mu.Lock()
+		n := len(b.buffer)
+		if n > b.opts.BatchSize {
+			n = b.opts.BatchSize
+		}
+		batch := b.buffer[:n:n]
+		b.buffer = b.buffer[n:]
+		b.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		err := b.sendWithRetry(batch)
+		if err == nil {
+			continue
+		}
+		if retry.IsPermanent(err) {
+			log.WithFields(log.Fields{
+				"output":  b.name,
+				"dropped": len(batch),
+				"err":     err,
+			}).Error("Batch rejected, dropping entries")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"output": b.name,
+			"err":    err,
+		}).Error("Sending batch failed, keeping entries for next flush")
+		b.mu.Lock()
+		b.buffer = append(batch, b.buffer...)
+		b.trim()
+		b.mu.Unlock()
+		return
+	}
+}
+
+func (b *batcher) sendWithRetry(batch [][]byte) error {
+	backoff := retry.Backoff{Min: b.opts.RetryBackoff, MaxRetries: b.opts.MaxRetries}
+	attempt := 0
+	return backoff.Do(b.stopCh, func() error {
+		attempt++
+		ctx, cancel := context.WithTimeout(context.Background(), b.opts.Timeout)
+		defer cancel()
+		err := b.send(ctx, batch)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"output":  b.name,
+				"attempt": attempt,
+				"err":     err,
+			}).Debug("Sending batch failed")
+		}
+		return err
+	})
+}
+
+// close flushes the remaining entries and stops the background goroutine.
+func (b *batcher) close() {
+	close(b.stopCh)
+	<-b.doneCh
+}
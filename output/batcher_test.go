@@ -0,0 +1,133 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcherBatchSize(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	b := newBatcher("test", BatchOptions{BatchSize: 3, FlushInterval: time.Hour}, func(ctx context.Context, batch [][]byte) error {
+		mu.Lock()
+		sizes = append(sizes, len(batch))
+		mu.Unlock()
+		return nil
+	})
+	for i := 0; i < 7; i++ {
+		b.add([]byte{byte(i)})
+	}
+	b.close()
+
+	total := 0
+	for _, size := range sizes {
+		if size > 3 {
+			t.Errorf("sent a batch of %d entries, want at most 3", size)
+		}
+		total += size
+	}
+	if total != 7 {
+		t.Errorf("sent %d entries in batches %v, want 7", total, sizes)
+	}
+}
+
+func TestBatcherFlushInterval(t *testing.T) {
+	sent := make(chan int, 1)
+	b := newBatcher("test", BatchOptions{BatchSize: 100, FlushInterval: 20 * time.Millisecond}, func(ctx context.Context, batch [][]byte) error {
+		sent <- len(batch)
+		return nil
+	})
+	defer b.close()
+
+	b.add([]byte("a"), []byte("b"))
+	select {
+	case n := <-sent:
+		if n != 2 {
+			t.Errorf("sent %d entries, want 2", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("entries not sent within the flush interval")
+	}
+}
+
+func TestBatcherRetry(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		buffered int
+	}{
+		{"server errors retried", []int{503, 429, 200}, 3, 0},
+		{"client error dropped", []int{400}, 1, 0},
+		{"retries used up", []int{500}, 3, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := make(chan struct{}, 10)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := test.statuses[0]
+				if len(test.statuses) > 1 {
+					test.statuses = test.statuses[1:]
+				}
+				w.WriteHeader(status)
+				requests <- struct{}{}
+			}))
+			defer server.Close()
+
+			influxdb := NewInfluxDB(server.URL, "", BatchOptions{
+				BatchSize:     1,
+				FlushInterval: time.Hour,
+				MaxRetries:    2,
+				RetryBackoff:  time.Millisecond,
+				Timeout:       time.Second,
+			})
+			defer influxdb.Close()
+			influxdb.batcher.add([]byte("m f=1i 1"))
+
+			for i := 0; i < test.attempts; i++ {
+				select {
+				case <-requests:
+				case <-time.After(time.Second):
+					t.Fatalf("got %d attempts, want %d", i, test.attempts)
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			if len(requests) > 0 {
+				t.Errorf("got more than %d attempts", test.attempts)
+			}
+			influxdb.batcher.mu.Lock()
+			buffered := len(influxdb.batcher.buffer)
+			influxdb.batcher.mu.Unlock()
+			if buffered != test.buffered {
+				t.Errorf("%d entries buffered, want %d", buffered, test.buffered)
+			}
+		})
+	}
+}
+
+func TestBatcherBoundaries(t *testing.T) {
+	sent := make(chan int, 10)
+	b := newBatcher("test", BatchOptions{BatchSize: 1, FlushInterval: time.Hour}, func(ctx context.Context, batch [][]byte) error {
+		sent <- len(batch)
+		return nil
+	})
+	defer b.close()
+
+	// Reaching the batch size flushes without waiting for the interval,
+	// and a batch size of 1 sends every entry on its own.
+	b.add([]byte("a"))
+	b.add([]byte("b"), []byte("c"))
+	for i := 0; i < 3; i++ {
+		select {
+		case n := <-sent:
+			if n != 1 {
+				t.Errorf("sent a batch of %d entries, want 1", n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("sent %d batches before the flush interval, want 3", i)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/retry"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// InfluxDB writes probe records in line protocol to an InfluxDB write
+// endpoint, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b or
+// http://localhost:8086/write?db=iperf3. Timestamps are in nanoseconds.
+type InfluxDB struct {
+	URL    string
+	Token  string
+	Client *http.Client
+
+	batcher *batcher
+}
+
+func NewInfluxDB(url, token string, opts BatchOptions) *InfluxDB {
+	i := &InfluxDB{
+		URL:    url,
+		Token:  token,
+		Client: http.DefaultClient,
+	}
+	i.batcher = newBatcher("influxdb", opts, i.send)
+	return i
+}
+
+func (i *InfluxDB) Write(record *collector.Record) error {
+	ps := points(record)
+	lines := make([][]byte, 0, len(ps))
+	for _, p := range ps {
+		lines = append(lines, lineProtocol(p))
+	}
+	i.batcher.add(lines...)
+	return nil
+}
+
+func (i *InfluxDB) Close() {
+	i.batcher.close()
+}
+
+func (i *InfluxDB) send(ctx context.Context, batch [][]byte) error {
+	body := bytes.Join(batch, []byte("\n"))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, i.URL, bytes.NewReader(body))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.Token != "" {
+		request.Header.Set("Authorization", "Token "+i.Token)
+	}
+	return do(i.Client, request)
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func lineProtocol(p *point) []byte {
+	var b bytes.Buffer
+	b.WriteString(measurementEscaper.Replace(p.measurement))
+	for _, key := range p.sortedTagKeys() {
+		if p.tags[key] == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(tagEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(tagEscaper.Replace(p.tags[key]))
+	}
+	for i, f := range p.fields {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(tagEscaper.Replace(f.name))
+		b.WriteByte('=')
+		switch v := f.value.(type) {
+		case int:
+			b.WriteString(strconv.Itoa(v))
+			b.WriteByte('i')
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(p.time.UnixNano(), 10))
+	return b.Bytes()
+}
+
+// do sends a request and classifies failures with retry.CheckResponse.
+func do(client *http.Client, request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return retry.CheckResponse(response)
+}
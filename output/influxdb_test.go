@@ -0,0 +1,91 @@
+package output
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecord has one interval of one TCP stream and the end summary.
+func testRecord() *collector.Record {
+	start := time.Unix(1600000000, 0)
+	return &collector.Record{
+		Target: "example.com",
+		Module: "default",
+		Labels: map[string]string{"site": "a b"},
+		Start:  start,
+		End:    start.Add(time.Second),
+		Results: &collector.Iperf3Results{
+			Intervals: []*collector.Iperf3Interval{{
+				Streams: []*collector.Iperf3IntervalStream{{
+					Socket:        5,
+					End:           1,
+					Seconds:       1,
+					Bytes:         125000000,
+					BitsPerSecond: 1e9,
+					Retransmits:   1,
+					RoundTripTime: 1500,
+					Sender:        true,
+				}},
+			}},
+			End: &collector.Iperf3End{
+				SummarySent: &collector.Iperf3SummarySent{
+					Seconds:       1,
+					Bytes:         125000000,
+					BitsPerSecond: 1e9,
+					Retransmits:   1,
+					Sender:        true,
+				},
+			},
+		},
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	p := &point{
+		measurement: "my measurement,x",
+		tags:        map[string]string{"b": "x y", "a=": "1,2", "empty": ""},
+		fields:      []field{{"f 1", 1}, {"g", 0.5}},
+		time:        time.Unix(1, 5),
+	}
+	want := `my\ measurement\,x,a\==1\,2,b=x\ y f\ 1=1i,g=0.5 1000000005`
+	if got := string(lineProtocol(p)); got != want {
+		t.Errorf("lineProtocol() = %s, want %s", got, want)
+	}
+}
+
+func TestInfluxDBWrite(t *testing.T) {
+	var header http.Header
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	influxdb := NewInfluxDB(server.URL, "secret", BatchOptions{BatchSize: 100, FlushInterval: time.Hour, Timeout: time.Second})
+	if err := influxdb.Write(testRecord()); err != nil {
+		t.Fatal(err)
+	}
+	influxdb.Close()
+
+	if got := header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("Authorization = %q, want %q", got, "Token secret")
+	}
+	if got := header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", got)
+	}
+	want := []string{
+		`iperf3_interval,direction=sender,module=default,omitted=false,site=a\ b,stream=5,target=example.com seconds=1,bytes=125000000i,bits_per_second=1e+09,retransmits=1i,snd_cwnd_bytes=0i,rtt_seconds=0.0015,rttvar_seconds=0,pmtu_bytes=0i 1600000001000000000`,
+		`iperf3_end,direction=sender,module=default,site=a\ b,stream=sum,target=example.com seconds=1,bytes=125000000i,bits_per_second=1e+09,retransmits=1i 1600000001000000000`,
+	}
+	if got := strings.Split(body, "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
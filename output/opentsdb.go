@@ -0,0 +1,87 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/retry"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// OpenTSDB writes probe records to the /api/put endpoint of OpenTSDB, e.g.
+// http://localhost:4242/api/put. Every field becomes a metric named after
+// its measurement, e.g. iperf3.interval.bits_per_second.
+type OpenTSDB struct {
+	URL    string
+	Client *http.Client
+
+	batcher *batcher
+}
+
+func NewOpenTSDB(url string, opts BatchOptions) *OpenTSDB {
+	o := &OpenTSDB{
+		URL:    url,
+		Client: http.DefaultClient,
+	}
+	o.batcher = newBatcher("opentsdb", opts, o.send)
+	return o
+}
+
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     interface{}       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// OpenTSDB only allows letters, digits and -_./ in metric names and tags.
+var openTSDBInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9\-_./]`)
+
+func (o *OpenTSDB) Write(record *collector.Record) error {
+	entries := [][]byte{}
+	for _, p := range points(record) {
+		tags := map[string]string{}
+		for key, value := range p.tags {
+			if value == "" {
+				continue
+			}
+			tags[openTSDBInvalidChars.ReplaceAllString(key, "_")] = openTSDBInvalidChars.ReplaceAllString(value, "_")
+		}
+		prefix := strings.Replace(p.measurement, "_", ".", 1) + "."
+		for _, f := range p.fields {
+			entry, err := json.Marshal(&openTSDBDataPoint{
+				Metric:    prefix + f.name,
+				Timestamp: p.time.UnixNano() / 1000000,
+				Value:     f.value,
+				Tags:      tags,
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	o.batcher.add(entries...)
+	return nil
+}
+
+func (o *OpenTSDB) Close() {
+	o.batcher.close()
+}
+
+func (o *OpenTSDB) send(ctx context.Context, batch [][]byte) error {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	body.Write(bytes.Join(batch, []byte(",")))
+	body.WriteByte(']')
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL, &body)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return do(o.Client, request)
+}
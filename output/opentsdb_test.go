@@ -0,0 +1,66 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenTSDBWrite(t *testing.T) {
+	var contentType string
+	var dataPoints []openTSDBDataPoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&dataPoints); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	opentsdb := NewOpenTSDB(server.URL, BatchOptions{BatchSize: 100, FlushInterval: time.Hour, Timeout: time.Second})
+	if err := opentsdb.Write(testRecord()); err != nil {
+		t.Fatal(err)
+	}
+	opentsdb.Close()
+
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	// 8 interval fields and 4 end fields.
+	if len(dataPoints) != 12 {
+		t.Fatalf("got %d data points, want 12", len(dataPoints))
+	}
+
+	got := dataPoints[2]
+	if got.Metric != "iperf3.interval.bits_per_second" {
+		t.Errorf("metric = %q, want iperf3.interval.bits_per_second", got.Metric)
+	}
+	if got.Timestamp != 1600000001000 {
+		t.Errorf("timestamp = %d, want 1600000001000", got.Timestamp)
+	}
+	if got.Value != 1e9 {
+		t.Errorf("value = %v, want 1e9", got.Value)
+	}
+	wantTags := map[string]string{
+		"direction": "sender",
+		"module":    "default",
+		"omitted":   "false",
+		"site":      "a_b",
+		"stream":    "5",
+		"target":    "example.com",
+	}
+	if len(got.Tags) != len(wantTags) {
+		t.Errorf("tags = %v, want %v", got.Tags, wantTags)
+	}
+	for key, value := range wantTags {
+		if got.Tags[key] != value {
+			t.Errorf("tag %s = %q, want %q", key, got.Tags[key], value)
+		}
+	}
+	if metric := dataPoints[8].Metric; metric != "iperf3.end.seconds" {
+		t.Errorf("metric = %q, want iperf3.end.seconds", metric)
+	}
+}
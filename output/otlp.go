@@ -0,0 +1,305 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/retry"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+
+	instrumentationScope = "github.com/fluepke/iperf3-exporter"
+)
+
+var (
+	rttBounds        = []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1}
+	throughputBounds = []float64{1e6, 1e7, 5e7, 1e8, 2.5e8, 5e8, 1e9, 2.5e9, 5e9, 1e10, 2.5e10, 5e10, 1e11}
+)
+
+type OTLPOptions struct {
+	// Endpoint is host:port for gRPC, or the full URL of the metrics
+	// endpoint for HTTP, e.g. http://localhost:4318/v1/metrics.
+	Endpoint string
+	Protocol string
+	Insecure bool
+	Headers  map[string]string
+}
+
+// OTLP exports probe records as OpenTelemetry metrics. Every record becomes
+// one resource carrying the exporter host, target, module and labels.
+type OTLP struct {
+	opts     OTLPOptions
+	hostname string
+
+	client  *http.Client
+	conn    *grpc.ClientConn
+	service colmetricpb.MetricsServiceClient
+
+	batcher *batcher
+}
+
+func NewOTLP(opts OTLPOptions, batchOptions BatchOptions) (*OTLP, error) {
+	o := &OTLP{
+		opts:   opts,
+		client: http.DefaultClient,
+	}
+	o.hostname, _ = os.Hostname()
+
+	switch opts.Protocol {
+	case OTLPProtocolGRPC:
+		transport := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+		if opts.Insecure {
+			transport = grpc.WithInsecure()
+		}
+		conn, err := grpc.Dial(opts.Endpoint, transport)
+		if err != nil {
+			return nil, err
+		}
+		o.conn = conn
+		o.service = colmetricpb.NewMetricsServiceClient(conn)
+	case OTLPProtocolHTTP:
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", opts.Protocol)
+	}
+
+	o.batcher = newBatcher("otlp", batchOptions, o.send)
+	return o, nil
+}
+
+func (o *OTLP) Write(record *collector.Record) error {
+	resourceMetrics, err := proto.Marshal(o.resourceMetrics(record))
+	if err != nil {
+		return err
+	}
+	// A batch is the concatenation of entries, each a resource_metrics field
+	// of an ExportMetricsServiceRequest.
+	entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, resourceMetrics)
+	o.batcher.add(entry)
+	return nil
+}
+
+func (o *OTLP) Close() {
+	o.batcher.close()
+	if o.conn != nil {
+		o.conn.Close()
+	}
+}
+
+func (o *OTLP) send(ctx context.Context, batch [][]byte) error {
+	body := bytes.Join(batch, nil)
+
+	if o.service != nil {
+		request := &colmetricpb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			return retry.Permanent(err)
+		}
+		if len(o.opts.Headers) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.opts.Headers))
+		}
+		_, err := o.service.Export(ctx, request)
+		switch status.Code(err) {
+		case codes.OK:
+			return nil
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+			return err
+		default:
+			return retry.Permanent(err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, o.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range o.opts.Headers {
+		request.Header.Set(name, value)
+	}
+	return do(o.client, request)
+}
+
+func (o *OTLP) resourceMetrics(record *collector.Record) *metricpb.ResourceMetrics {
+	attributes := map[string]string{
+		"service.name":  "iperf3-exporter",
+		"host.name":     o.hostname,
+		"iperf3.target": record.Target,
+		"iperf3.module": record.Module,
+	}
+	for name, value := range record.Labels {
+		attributes[name] = value
+	}
+
+	return &metricpb.ResourceMetrics{
+		Resource: &resourcepb.Resource{Attributes: keyValues(attributes)},
+		ScopeMetrics: []*metricpb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: instrumentationScope},
+			Metrics: otlpMetrics(record),
+		}},
+	}
+}
+
+func otlpMetrics(record *collector.Record) []*metricpb.Metric {
+	r := record.Results
+	start := uint64(record.Start.UnixNano())
+	end := uint64(record.End.UnixNano())
+	gauges := map[string]*metricpb.Metric{}
+	gauge := func(name, description, unit string, value float64, attributes map[string]string) {
+		metric, ok := gauges[name]
+		if !ok {
+			metric = &metricpb.Metric{
+				Name:        name,
+				Description: description,
+				Unit:        unit,
+				Data:        &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{}},
+			}
+			gauges[name] = metric
+		}
+		data := metric.GetGauge()
+		data.DataPoints = append(data.DataPoints, &metricpb.NumberDataPoint{
+			Attributes:        keyValues(attributes),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      end,
+			Value:             &metricpb.NumberDataPoint_AsDouble{AsDouble: value},
+		})
+	}
+
+	metrics := []*metricpb.Metric{}
+	if r.End != nil {
+		if sent := r.End.SummarySent; sent != nil {
+			gauge("iperf3.throughput", "Throughput over the whole test", "bit/s", sent.BitsPerSecond, map[string]string{"direction": "sender"})
+			gauge("iperf3.transferred", "Bytes transferred over the whole test", "By", float64(sent.Bytes), map[string]string{"direction": "sender"})
+			gauge("iperf3.retransmits", "TCP retransmits over the whole test", "{retransmit}", float64(sent.Retransmits), nil)
+		}
+		if received := r.End.SummaryReceived; received != nil {
+			gauge("iperf3.throughput", "Throughput over the whole test", "bit/s", received.BitsPerSecond, map[string]string{"direction": "receiver"})
+			gauge("iperf3.transferred", "Bytes transferred over the whole test", "By", float64(received.Bytes), map[string]string{"direction": "receiver"})
+		}
+		if udp := r.End.Summary; udp != nil && udp.Packets > 0 {
+			gauge("iperf3.udp.jitter", "UDP jitter", "s", udp.JitterMs/1000, nil)
+			gauge("iperf3.udp.lost", "Ratio of lost UDP packets", "1", udp.LostPercent/100, nil)
+		}
+		for _, stream := range r.End.Streams {
+			if sender := stream.Sender; sender != nil && sender.MeanRoundTripTime > 0 {
+				socket := strconv.Itoa(sender.Socket)
+				gauge("iperf3.rtt", "Round trip time of a stream", "s", sender.MinRoundTripTime/1000000, map[string]string{"stream": socket, "statistic": "min"})
+				gauge("iperf3.rtt", "Round trip time of a stream", "s", sender.MeanRoundTripTime/1000000, map[string]string{"stream": socket, "statistic": "mean"})
+				gauge("iperf3.rtt", "Round trip time of a stream", "s", sender.MaxRoundTripTime/1000000, map[string]string{"stream": socket, "statistic": "max"})
+			}
+		}
+		if cpu := r.End.CpuUsage; cpu != nil {
+			for _, c := range []struct {
+				host, state string
+				value       float64
+			}{
+				{"local", "total", cpu.HostTotal},
+				{"local", "user", cpu.HostUser},
+				{"local", "system", cpu.HostSystem},
+				{"remote", "total", cpu.RemoteTotal},
+				{"remote", "user", cpu.RemoteUser},
+				{"remote", "system", cpu.RemoteSystem},
+			} {
+				gauge("iperf3.cpu.utilization", "CPU utilization during the test", "1", c.value/100, map[string]string{"host": c.host, "state": c.state})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, gauges[name])
+	}
+
+	var rtts, throughputs []float64
+	for _, interval := range r.Intervals {
+		for _, stream := range interval.Streams {
+			if stream.Omitted {
+				continue
+			}
+			throughputs = append(throughputs, stream.BitsPerSecond)
+			if stream.RoundTripTime > 0 {
+				rtts = append(rtts, stream.RoundTripTime/1000000)
+			}
+		}
+	}
+	if len(throughputs) > 0 {
+		metrics = append(metrics, histogram("iperf3.interval.throughput", "Throughput of a stream per interval", "bit/s", throughputs, throughputBounds, start, end))
+	}
+	if len(rtts) > 0 {
+		metrics = append(metrics, histogram("iperf3.interval.rtt", "Round trip time of a stream per interval", "s", rtts, rttBounds, start, end))
+	}
+	return metrics
+}
+
+func histogram(name, description, unit string, values, bounds []float64, start, end uint64) *metricpb.Metric {
+	point := &metricpb.HistogramDataPoint{
+		StartTimeUnixNano: start,
+		TimeUnixNano:      end,
+		Count:             uint64(len(values)),
+		BucketCounts:      make([]uint64, len(bounds)+1),
+		ExplicitBounds:    bounds,
+	}
+	var sum float64
+	min, max := values[0], values[0]
+	for _, value := range values {
+		sum += value
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+		point.BucketCounts[sort.SearchFloat64s(bounds, value)]++
+	}
+	point.Sum, point.Min, point.Max = &sum, &min, &max
+
+	return &metricpb.Metric{
+		Name:        name,
+		Description: description,
+		Unit:        unit,
+		Data: &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			DataPoints:             []*metricpb.HistogramDataPoint{point},
+			AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+		}},
+	}
+}
+
+func keyValues(attributes map[string]string) []*commonpb.KeyValue {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(names))
+	for _, name := range names {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   name,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attributes[name]}},
+		})
+	}
+	return kvs
+}
@@ -0,0 +1,203 @@
+package output
+
+import (
+	"context"
+	"github.com/fluepke/iperf3-exporter/collector"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func attributeMap(kvs []*commonpb.KeyValue) map[string]string {
+	attributes := map[string]string{}
+	for _, kv := range kvs {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attributes
+}
+
+// writeOTLP writes two records to o, which are sent in one request.
+func writeOTLP(t *testing.T, o *OTLP) {
+	first := testRecord()
+	second := testRecord()
+	second.Target = "example.org"
+	for _, record := range []*collector.Record{first, second} {
+		if err := o.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.Close()
+}
+
+// checkExportRequest checks the request sent for the records of writeOTLP.
+func checkExportRequest(t *testing.T, request *colmetricpb.ExportMetricsServiceRequest) {
+	if len(request.ResourceMetrics) != 2 {
+		t.Fatalf("got %d resource metrics, want 2", len(request.ResourceMetrics))
+	}
+	hostname, _ := os.Hostname()
+	for i, target := range []string{"example.com", "example.org"} {
+		got := attributeMap(request.ResourceMetrics[i].Resource.Attributes)
+		want := map[string]string{
+			"service.name":  "iperf3-exporter",
+			"host.name":     hostname,
+			"iperf3.target": target,
+			"iperf3.module": "default",
+			"site":          "a b",
+		}
+		if len(got) != len(want) {
+			t.Errorf("resource attributes = %v, want %v", got, want)
+		}
+		for name, value := range want {
+			if got[name] != value {
+				t.Errorf("resource attribute %s = %q, want %q", name, got[name], value)
+			}
+		}
+	}
+
+	scopeMetrics := request.ResourceMetrics[0].ScopeMetrics
+	if len(scopeMetrics) != 1 || scopeMetrics[0].Scope.Name != instrumentationScope {
+		t.Fatalf("scope metrics = %v, want one of scope %s", scopeMetrics, instrumentationScope)
+	}
+	metrics := map[string]*metricpb.Metric{}
+	var names []string
+	for _, metric := range scopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+		names = append(names, metric.Name)
+	}
+	wantNames := []string{"iperf3.retransmits", "iperf3.throughput", "iperf3.transferred", "iperf3.interval.throughput", "iperf3.interval.rtt"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("metrics = %v, want %v", names, wantNames)
+	}
+	for i := range names {
+		if names[i] != wantNames[i] {
+			t.Errorf("metrics = %v, want %v", names, wantNames)
+			break
+		}
+	}
+
+	throughput := metrics["iperf3.throughput"]
+	if throughput.GetGauge() == nil || len(throughput.GetGauge().DataPoints) != 1 {
+		t.Fatalf("iperf3.throughput = %v, want a gauge with one data point", throughput)
+	}
+	point := throughput.GetGauge().DataPoints[0]
+	if point.GetAsDouble() != 1e9 || attributeMap(point.Attributes)["direction"] != "sender" {
+		t.Errorf("iperf3.throughput = %v, want 1e9 sent", point)
+	}
+	if point.StartTimeUnixNano != 1600000000000000000 || point.TimeUnixNano != 1600000001000000000 {
+		t.Errorf("iperf3.throughput spans %d to %d, want the probe", point.StartTimeUnixNano, point.TimeUnixNano)
+	}
+	if throughput.Unit != "bit/s" {
+		t.Errorf("iperf3.throughput unit = %q, want bit/s", throughput.Unit)
+	}
+
+	for name, bucket := range map[string]int{
+		"iperf3.interval.throughput": 6, // (5e8, 1e9]
+		"iperf3.interval.rtt":        2, // (0.001, 0.002]
+	} {
+		histogram := metrics[name].GetHistogram()
+		if histogram == nil || len(histogram.DataPoints) != 1 {
+			t.Errorf("%s = %v, want a histogram with one data point", name, metrics[name])
+			continue
+		}
+		if histogram.AggregationTemporality != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+			t.Errorf("%s temporality = %s, want delta", name, histogram.AggregationTemporality)
+		}
+		point := histogram.DataPoints[0]
+		if point.Count != 1 || len(point.BucketCounts) != len(point.ExplicitBounds)+1 || point.BucketCounts[bucket] != 1 {
+			t.Errorf("%s count %d in buckets %v, want 1 in bucket %d", name, point.Count, point.BucketCounts, bucket)
+		}
+	}
+}
+
+type testMetricsService struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	requests chan *colmetricpb.ExportMetricsServiceRequest
+	metadata chan metadata.MD
+}
+
+func (s *testMetricsService) Export(ctx context.Context, request *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.metadata <- md
+	s.requests <- request
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &testMetricsService{
+		requests: make(chan *colmetricpb.ExportMetricsServiceRequest, 1),
+		metadata: make(chan metadata.MD, 1),
+	}
+	server := grpc.NewServer()
+	colmetricpb.RegisterMetricsServiceServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	o, err := NewOTLP(OTLPOptions{
+		Endpoint: listener.Addr().String(),
+		Protocol: OTLPProtocolGRPC,
+		Insecure: true,
+		Headers:  map[string]string{"x-tenant": "iperf3"},
+	}, BatchOptions{BatchSize: 100, FlushInterval: time.Hour, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeOTLP(t, o)
+
+	select {
+	case md := <-service.metadata:
+		if got := md.Get("x-tenant"); len(got) != 1 || got[0] != "iperf3" {
+			t.Errorf("x-tenant = %v, want iperf3", got)
+		}
+		checkExportRequest(t, <-service.requests)
+	default:
+		t.Fatal("no export request received")
+	}
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	var header http.Header
+	request := &colmetricpb.ExportMetricsServiceRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, request); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	o, err := NewOTLP(OTLPOptions{
+		Endpoint: server.URL + "/v1/metrics",
+		Protocol: OTLPProtocolHTTP,
+		Headers:  map[string]string{"X-Tenant": "iperf3"},
+	}, BatchOptions{BatchSize: 100, FlushInterval: time.Hour, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeOTLP(t, o)
+
+	if header == nil {
+		t.Fatal("no export request received")
+	}
+	if got := header.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("Content-Type = %q, want application/x-protobuf", got)
+	}
+	if got := header.Get("X-Tenant"); got != "iperf3" {
+		t.Errorf("X-Tenant = %q, want iperf3", got)
+	}
+	checkExportRequest(t, request)
+}
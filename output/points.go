@@ -0,0 +1,190 @@
+package output
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// point is a measurement independent of the output format.
+type point struct {
+	measurement string
+	tags        map[string]string
+	fields      []field
+	time        time.Time
+}
+
+type field struct {
+	name  string
+	value interface{} // int or float64
+}
+
+func (p *point) sortedTagKeys() []string {
+	keys := make([]string, 0, len(p.tags))
+	for key := range p.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func direction(sender bool) string {
+	if sender {
+		return "sender"
+	}
+	return "receiver"
+}
+
+// points converts a probe record into interval, end and cpu measurements.
+// Interval points are timestamped relative to the start of the probe, end
+// and cpu points with the end of the probe.
+func points(record *collector.Record) []*point {
+	r := record.Results
+	tags := func(extra map[string]string) map[string]string {
+		t := map[string]string{}
+		for name, value := range record.Labels {
+			t[name] = value
+		}
+		t["target"] = record.Target
+		t["module"] = record.Module
+		for name, value := range extra {
+			t[name] = value
+		}
+		return t
+	}
+	offset := func(seconds float64) time.Time {
+		return record.Start.Add(time.Duration(seconds * float64(time.Second)))
+	}
+
+	ps := []*point{}
+	for _, interval := range r.Intervals {
+		for _, stream := range interval.Streams {
+			ps = append(ps, &point{
+				measurement: "iperf3_interval",
+				tags: tags(map[string]string{
+					"direction": direction(stream.Sender),
+					"stream":    strconv.Itoa(stream.Socket),
+					"omitted":   strconv.FormatBool(stream.Omitted),
+				}),
+				fields: []field{
+					{"seconds", stream.Seconds},
+					{"bytes", stream.Bytes},
+					{"bits_per_second", stream.BitsPerSecond},
+					{"retransmits", stream.Retransmits},
+					{"snd_cwnd_bytes", stream.SendCongestionWindowSize},
+					{"rtt_seconds", stream.RoundTripTime / 1000000},
+					{"rttvar_seconds", stream.RoundTripTimeVariance / 1000000},
+					{"pmtu_bytes", stream.PathMTU},
+				},
+				time: offset(stream.End),
+			})
+		}
+		if summary := interval.Summary; summary != nil {
+			ps = append(ps, &point{
+				measurement: "iperf3_interval",
+				tags: tags(map[string]string{
+					"direction": direction(summary.Sender),
+					"stream":    "sum",
+					"omitted":   strconv.FormatBool(summary.Omitted),
+				}),
+				fields: []field{
+					{"seconds", summary.Seconds},
+					{"bytes", summary.Bytes},
+					{"bits_per_second", summary.BitsPerSecond},
+					{"retransmits", summary.Retransmits},
+				},
+				time: offset(summary.End),
+			})
+		}
+	}
+
+	if r.End == nil {
+		return ps
+	}
+	for _, stream := range r.End.Streams {
+		if sender := stream.Sender; sender != nil {
+			ps = append(ps, &point{
+				measurement: "iperf3_end",
+				tags:        tags(map[string]string{"direction": "sender", "stream": strconv.Itoa(sender.Socket)}),
+				fields: []field{
+					{"seconds", sender.Seconds},
+					{"bytes", sender.Bytes},
+					{"bits_per_second", sender.BitsPerSecond},
+					{"retransmits", sender.Retransmits},
+					{"max_snd_cwnd_bytes", sender.MaxSendCongestionWindowSize},
+					{"max_rtt_seconds", sender.MaxRoundTripTime / 1000000},
+					{"min_rtt_seconds", sender.MinRoundTripTime / 1000000},
+					{"mean_rtt_seconds", sender.MeanRoundTripTime / 1000000},
+				},
+				time: record.End,
+			})
+		}
+		if receiver := stream.Receiver; receiver != nil {
+			ps = append(ps, &point{
+				measurement: "iperf3_end",
+				tags:        tags(map[string]string{"direction": "receiver", "stream": strconv.Itoa(receiver.Socket)}),
+				fields: []field{
+					{"seconds", receiver.Seconds},
+					{"bytes", receiver.Bytes},
+					{"bits_per_second", receiver.BitsPerSecond},
+				},
+				time: record.End,
+			})
+		}
+	}
+	if sent := r.End.SummarySent; sent != nil {
+		ps = append(ps, &point{
+			measurement: "iperf3_end",
+			tags:        tags(map[string]string{"direction": "sender", "stream": "sum"}),
+			fields: []field{
+				{"seconds", sent.Seconds},
+				{"bytes", sent.Bytes},
+				{"bits_per_second", sent.BitsPerSecond},
+				{"retransmits", sent.Retransmits},
+			},
+			time: record.End,
+		})
+	}
+	if received := r.End.SummaryReceived; received != nil {
+		ps = append(ps, &point{
+			measurement: "iperf3_end",
+			tags:        tags(map[string]string{"direction": "receiver", "stream": "sum"}),
+			fields: []field{
+				{"seconds", received.Seconds},
+				{"bytes", received.Bytes},
+				{"bits_per_second", received.BitsPerSecond},
+			},
+			time: record.End,
+		})
+	}
+	if udp := r.End.Summary; udp != nil && udp.Packets > 0 {
+		ps = append(ps, &point{
+			measurement: "iperf3_end",
+			tags:        tags(map[string]string{"direction": direction(udp.Sender), "stream": "sum"}),
+			fields: []field{
+				{"jitter_seconds", udp.JitterMs / 1000},
+				{"lost_packets", udp.LostPackets},
+				{"packets", udp.Packets},
+				{"lost_percent", udp.LostPercent},
+			},
+			time: record.End,
+		})
+	}
+	if cpu := r.End.CpuUsage; cpu != nil {
+		ps = append(ps, &point{
+			measurement: "iperf3_cpu",
+			tags:        tags(nil),
+			fields: []field{
+				{"host_total_percent", cpu.HostTotal},
+				{"host_user_percent", cpu.HostUser},
+				{"host_system_percent", cpu.HostSystem},
+				{"remote_total_percent", cpu.RemoteTotal},
+				{"remote_user_percent", cpu.RemoteUser},
+				{"remote_system_percent", cpu.RemoteSystem},
+			},
+			time: record.End,
+		})
+	}
+	return ps
+}
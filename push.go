@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exit codes of the push command.
+const (
+	pushExitSuccess     = 0
+	pushExitProbeFailed = 1
+	pushExitUsage       = 2
+	pushExitPushFailed  = 3
+)
+
+// labelFlags collects repeated -label name=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := []string{}
+	for name, value := range l {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("label must be name=value")
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
+// runPush probes a target once and pushes the metrics to a Pushgateway,
+// grouped by job, target, module and additional labels.
+func runPush(args []string) int {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	url := flags.String("pushgateway.url", "", "Pushgateway URL")
+	job := flags.String("job", "iperf3", "Job grouping key")
+	target := flags.String("target", "", "Target to probe")
+	moduleName := flags.String("module", "default", "Module to probe with")
+	add := flags.Bool("add", false, "Add the metrics to the group instead of replacing all metrics of the group")
+	deleteGroup := flags.Bool("delete", false, "Delete the group from the Pushgateway instead of probing")
+	username := flags.String("username", "", "Basic auth username for the Pushgateway")
+	passwordFile := flags.String("password-file", "", "File containing the basic auth password for the Pushgateway")
+	labels := labelFlags{}
+	flags.Var(labels, "label", "Additional grouping key as name=value, may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] push [push flags]\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Exit status is %d on success, %d if the probe failed, %d on invalid usage and %d if pushing failed.\n\n",
+			pushExitSuccess, pushExitProbeFailed, pushExitUsage, pushExitPushFailed)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *url == "" || *target == "" {
+		flags.Usage()
+		return pushExitUsage
+	}
+	module, ok := conf.Modules[*moduleName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown module %q\n", *moduleName)
+		return pushExitUsage
+	}
+
+	pusher := push.New(*url, *job).
+		Grouping("target", *target).
+		Grouping("module", *moduleName)
+	for name, value := range labels {
+		pusher = pusher.Grouping(name, value)
+	}
+	if *username != "" {
+		password, err := readSecret(*passwordFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return pushExitUsage
+		}
+		pusher = pusher.BasicAuth(*username, password)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"pushgateway": *url,
+		"job":         *job,
+		"target":      *target,
+		"module":      *moduleName,
+	})
+
+	if *deleteGroup {
+		if err := pusher.Delete(); err != nil {
+			logger.WithFields(log.Fields{
+				"err": err,
+			}).Error("Deleting group from Pushgateway failed")
+			return pushExitPushFailed
+		}
+		logger.Info("Deleted group from Pushgateway")
+		return pushExitSuccess
+	}
+
+	iperf3Collector := newModuleCollector(*target, *moduleName, module)
+	iperf3Collector.Labels = labels
+
+	// Every resolved address is probed in turn and its metrics labelled
+	// with it, all in the same group.
+	collectors, probeErr := iperf3Collector.expand(context.Background())
+	gatherers := prometheus.Gatherers{}
+	if probeErr != nil {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&collector.ResultsCollector{})
+		gatherers = append(gatherers, registry)
+	}
+	for _, c := range collectors {
+		start := time.Now()
+		results, err := c.Run(context.Background())
+		if err != nil {
+			probeErr = err
+		}
+		duration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "iperf3_probe_duration_seconds",
+			Help: "Duration of the probe.",
+		})
+		duration.Set(time.Since(start).Seconds())
+
+		registry := prometheus.NewRegistry()
+		prometheus.WrapRegistererWith(probeLabels(c), registry).MustRegister(&collector.ResultsCollector{Results: results}, duration)
+		gatherers = append(gatherers, registry)
+	}
+
+	pusher = pusher.Gatherer(gatherers)
+	var err error
+	if *add {
+		err = pusher.Add()
+	} else {
+		err = pusher.Push()
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err": err,
+		}).Error("Pushing to Pushgateway failed")
+		return pushExitPushFailed
+	}
+
+	if probeErr != nil {
+		return pushExitProbeFailed
+	}
+	logger.Info("Pushed probe results to Pushgateway")
+	return pushExitSuccess
+}
@@ -0,0 +1,177 @@
+package remotewrite
+
+import (
+	"fmt"
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Field numbers of the remote write protobuf messages, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/types.proto
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// timeSeries flattens metric families into one series per sample. Summaries
+// and histograms are expanded into their _sum, _count, quantile and bucket
+// series.
+func timeSeries(families []*dto.MetricFamily, extra map[string]string, timestamp int64) []*series {
+	result := []*series{}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			ts := timestamp
+			if metric.TimestampMs != nil {
+				ts = *metric.TimestampMs
+			}
+			add := func(suffix string, value float64, more ...label) {
+				labels := []label{{"__name__", family.GetName() + suffix}}
+				for name, v := range extra {
+					labels = append(labels, label{name, v})
+				}
+				for _, pair := range metric.Label {
+					labels = append(labels, label{pair.GetName(), pair.GetValue()})
+				}
+				labels = append(labels, more...)
+				result = append(result, &series{labels: sortLabels(labels), value: value, timestamp: ts})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				add("", metric.GetGauge().GetValue())
+			case dto.MetricType_COUNTER:
+				add("", metric.GetCounter().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, q := range summary.Quantile {
+					add("", q.GetValue(), label{"quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)})
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, b := range histogram.Bucket {
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)})
+				}
+				add("_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				add("_sum", histogram.GetSampleSum())
+				add("_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return result
+}
+
+// sortLabels sorts labels by name, as required by remote write, keeping the
+// last value of duplicate names so metric labels override extra labels.
+func sortLabels(labels []label) []label {
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	result := labels[:0]
+	for _, l := range labels {
+		if len(result) > 0 && result[len(result)-1].name == l.name {
+			result[len(result)-1] = l
+			continue
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+func encodeWriteRequest(ss []*series) []byte {
+	var request []byte
+	for _, s := range ss {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		var sb []byte
+		sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
+
+// countSamples counts the samples in a compressed write request.
+func countSamples(compressed []byte) (int, error) {
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return 0, err
+	}
+
+	samples := 0
+	err = walk(data, func(num protowire.Number, value []byte) error {
+		if num != writeRequestTimeseries {
+			return nil
+		}
+		return walk(value, func(num protowire.Number, _ []byte) error {
+			if num == timeSeriesSamples {
+				samples++
+			}
+			return nil
+		})
+	})
+	return samples, err
+}
+
+// walk calls fn for every length-delimited field of a message and skips
+// all others.
+func walk(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("malformed write request: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("malformed write request: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return fmt.Errorf("malformed write request: %w", protowire.ParseError(n))
+		}
+		if err := fn(num, value); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/retry"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentSuffix = ".snappy"
+
+type Options struct {
+	URL         string
+	BearerToken string
+	Username    string
+	Password    string
+	Timeout     time.Duration
+
+	// WALDir keeps pending write requests on disk so they survive outages
+	// and restarts. If empty, they are only kept in memory.
+	WALDir string
+	// Pending requests beyond MaxPending or older than MaxAge are dropped,
+	// oldest first. Zero disables the respective limit.
+	MaxPending int
+	MaxAge     time.Duration
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Writer sends samples to a Prometheus remote-write endpoint. Every call to
+// Write becomes one snappy-compressed write request that is queued and sent
+// in order, retrying with exponential backoff until it is accepted.
+type Writer struct {
+	opts   Options
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []*segment
+	seq     uint64
+	notify  chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+
+	samplesTotal        prometheus.Counter
+	requestsFailedTotal prometheus.Counter
+	droppedTotal        prometheus.Counter
+	pendingGauge        prometheus.GaugeFunc
+}
+
+type segment struct {
+	created time.Time
+	path    string // empty if kept in memory
+	data    []byte
+}
+
+func New(opts Options) (*Writer, error) {
+	w := &Writer{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		notify: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+
+		samplesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "iperf3_exporter_remote_write_samples_total",
+			Help: "Samples sent via remote write.",
+		}),
+		requestsFailedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "iperf3_exporter_remote_write_failed_requests_total",
+			Help: "Remote write requests that failed.",
+		}),
+		droppedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "iperf3_exporter_remote_write_dropped_requests_total",
+			Help: "Remote write requests dropped because they were rejected or exceeded the queue limits.",
+		}),
+	}
+	w.pendingGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "iperf3_exporter_remote_write_pending_requests",
+		Help: "Remote write requests waiting to be sent.",
+	}, func() float64 {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return float64(len(w.pending))
+	})
+
+	if opts.WALDir != "" {
+		if err := w.loadWAL(); err != nil {
+			return nil, err
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *Writer) Describe(ch chan<- *prometheus.Desc) {
+	w.samplesTotal.Describe(ch)
+	w.requestsFailedTotal.Describe(ch)
+	w.droppedTotal.Describe(ch)
+	w.pendingGauge.Describe(ch)
+}
+
+func (w *Writer) Collect(ch chan<- prometheus.Metric) {
+	w.samplesTotal.Collect(ch)
+	w.requestsFailedTotal.Collect(ch)
+	w.droppedTotal.Collect(ch)
+	w.pendingGauge.Collect(ch)
+}
+
+func (w *Writer) loadWAL() error {
+	if err := os.MkdirAll(w.opts.WALDir, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(w.opts.WALDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		w.pending = append(w.pending, &segment{
+			created: entry.ModTime(),
+			path:    filepath.Join(w.opts.WALDir, entry.Name()),
+		})
+	}
+	sort.Slice(w.pending, func(i, j int) bool { return w.pending[i].path < w.pending[j].path })
+	if len(w.pending) > 0 {
+		log.WithFields(log.Fields{
+			"pending": len(w.pending),
+		}).Info("Resuming remote write from WAL")
+	}
+	return nil
+}
+
+// Write queues the samples of families, with labels added to every series
+// and timestamped with timestamp unless a metric carries its own.
+func (w *Writer) Write(families []*dto.MetricFamily, labels map[string]string, timestamp time.Time) error {
+	series := timeSeries(families, labels, timestamp.UnixNano()/int64(time.Millisecond))
+	if len(series) == 0 {
+		return nil
+	}
+	data := snappy.Encode(nil, encodeWriteRequest(series))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := &segment{created: time.Now()}
+	if w.opts.WALDir != "" {
+		w.seq++
+		s.path = filepath.Join(w.opts.WALDir, fmt.Sprintf("%020d-%06d%s", s.created.UnixNano(), w.seq%1000000, segmentSuffix))
+		if err := ioutil.WriteFile(s.path+".tmp", data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(s.path+".tmp", s.path); err != nil {
+			return err
+		}
+	} else {
+		s.data = data
+	}
+	w.pending = append(w.pending, s)
+	w.enforceLimits()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// enforceLimits drops the oldest pending requests. w.mu must be held.
+func (w *Writer) enforceLimits() {
+	for len(w.pending) > 0 {
+		oldest := w.pending[0]
+		tooMany := w.opts.MaxPending > 0 && len(w.pending) > w.opts.MaxPending
+		tooOld := w.opts.MaxAge > 0 && time.Since(oldest.created) > w.opts.MaxAge
+		if !tooMany && !tooOld {
+			return
+		}
+		w.drop(oldest)
+		w.pending = w.pending[1:]
+	}
+}
+
+func (w *Writer) drop(s *segment) {
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	w.droppedTotal.Inc()
+}
+
+func (w *Writer) loop() {
+	defer close(w.doneCh)
+	backoff := retry.Backoff{Min: w.opts.MinBackoff, Max: w.opts.MaxBackoff, MaxRetries: -1}
+
+	for {
+		next := w.head()
+		if next == nil {
+			select {
+			case <-w.notify:
+				continue
+			case <-w.stopCh:
+				return
+			}
+		}
+
+		var samples int
+		err := backoff.Do(w.stopCh, func() error {
+			// The limits may drop the request while it is retried.
+			if w.head() != next {
+				return nil
+			}
+			var err error
+			samples, err = w.send(next)
+			if err != nil && !retry.IsPermanent(err) {
+				w.requestsFailedTotal.Inc()
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Warn("Remote write failed, retrying")
+			}
+			return err
+		})
+		if err != nil && !retry.IsPermanent(err) {
+			// Stopped while retrying.
+			return
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("Remote write request rejected, dropping it")
+		}
+
+		w.mu.Lock()
+		if len(w.pending) > 0 && w.pending[0] == next {
+			w.pending = w.pending[1:]
+			if err != nil {
+				w.drop(next)
+			} else if next.path != "" {
+				os.Remove(next.path)
+			}
+		}
+		w.mu.Unlock()
+		w.samplesTotal.Add(float64(samples))
+	}
+}
+
+// head returns the oldest pending request within the limits, or nil if there
+// is none.
+func (w *Writer) head() *segment {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enforceLimits()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	return w.pending[0]
+}
+
+func (w *Writer) send(s *segment) (int, error) {
+	data := s.data
+	if s.path != "" {
+		var err error
+		data, err = ioutil.ReadFile(s.path)
+		if err != nil {
+			return 0, retry.Permanent(err)
+		}
+	}
+	samples, err := countSamples(data)
+	if err != nil {
+		return 0, retry.Permanent(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, retry.Permanent(err)
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	request.Header.Set("User-Agent", "iperf3-exporter")
+	if w.opts.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+w.opts.BearerToken)
+	} else if w.opts.Username != "" {
+		request.SetBasicAuth(w.opts.Username, w.opts.Password)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// As specified for remote write, only 5xx and 429 are retried.
+	if err := retry.CheckResponse(response); err != nil {
+		return 0, err
+	}
+	return samples, nil
+}
+
+// Close stops sending. Pending requests remain in the WAL for the next start.
+func (w *Writer) Close() {
+	close(w.stopCh)
+	<-w.doneCh
+}
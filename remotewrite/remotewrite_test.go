@@ -0,0 +1,252 @@
+package remotewrite
+
+import (
+	"encoding/json"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeRequestFile describes the messages of prompb/remote.proto and
+// prompb/types.proto used by remote write, to decode requests independently
+// of the encoder.
+var writeRequestFile = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("remote.proto"),
+	Package: proto.String("prometheus"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		message("WriteRequest", messageField("timeseries", 1, ".prometheus.TimeSeries")),
+		message("TimeSeries", messageField("labels", 1, ".prometheus.Label"), messageField("samples", 2, ".prometheus.Sample")),
+		message("Label", scalarField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING), scalarField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+		message("Sample", scalarField("value", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE), scalarField("timestamp", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64)),
+	},
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func messageField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String(typeName),
+	}
+}
+
+func scalarField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+	}
+}
+
+type testSeries struct {
+	Labels []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"labels"`
+	Samples []struct {
+		Value     float64 `json:"value"`
+		Timestamp int64   `json:"timestamp,string"`
+	} `json:"samples"`
+}
+
+// decodeWriteRequest decodes a snappy-compressed write request.
+func decodeWriteRequest(t *testing.T, compressed []byte) []testSeries {
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatalf("decompressing request: %s", err)
+	}
+	file, err := protodesc.NewFile(writeRequestFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := dynamicpb.NewMessage(file.Messages().ByName("WriteRequest"))
+	if err := proto.Unmarshal(data, request); err != nil {
+		t.Fatalf("decoding request: %s", err)
+	}
+	b, err := protojson.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Timeseries []testSeries `json:"timeseries"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded.Timeseries
+}
+
+func testFamilies() []*dto.MetricFamily {
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("iperf3_sent_bytes"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label:       []*dto.LabelPair{{Name: proto.String("target"), Value: proto.String("metric")}},
+				Gauge:       &dto.Gauge{Value: proto.Float64(1000)},
+				TimestampMs: proto.Int64(1599999999000),
+			}},
+		},
+		{
+			Name: proto.String("iperf3_rtt_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(0.02),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(0.01), CumulativeCount: proto.Uint64(3)},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func TestWriterSend(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	w, err := New(Options{URL: server.URL, Username: "user", Password: "secret", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"instance": "probe", "target": "extra"}
+	if err := w.Write(testFamilies(), labels, time.Unix(1600000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	var request *http.Request
+	var body []byte
+	select {
+	case request = <-requests:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("no write request received")
+	}
+	w.Close()
+
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := request.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if user, password, _ := request.BasicAuth(); user != "user" || password != "secret" {
+		t.Errorf("basic auth = %s:%s, want user:secret", user, password)
+	}
+
+	type sample struct {
+		labels    [][2]string
+		value     float64
+		timestamp int64
+	}
+	want := []sample{
+		{[][2]string{{"__name__", "iperf3_sent_bytes"}, {"instance", "probe"}, {"target", "metric"}}, 1000, 1599999999000},
+		{[][2]string{{"__name__", "iperf3_rtt_seconds_bucket"}, {"instance", "probe"}, {"le", "0.01"}, {"target", "extra"}}, 3, 1600000000000},
+		{[][2]string{{"__name__", "iperf3_rtt_seconds_bucket"}, {"instance", "probe"}, {"le", "+Inf"}, {"target", "extra"}}, 4, 1600000000000},
+		{[][2]string{{"__name__", "iperf3_rtt_seconds_sum"}, {"instance", "probe"}, {"target", "extra"}}, 0.02, 1600000000000},
+		{[][2]string{{"__name__", "iperf3_rtt_seconds_count"}, {"instance", "probe"}, {"target", "extra"}}, 4, 1600000000000},
+	}
+	var got []sample
+	for _, series := range decodeWriteRequest(t, body) {
+		var labels [][2]string
+		for _, l := range series.Labels {
+			labels = append(labels, [2]string{l.Name, l.Value})
+		}
+		if len(series.Samples) != 1 {
+			t.Errorf("series %v has %d samples, want 1", labels, len(series.Samples))
+			continue
+		}
+		got = append(got, sample{labels, series.Samples[0].Value, series.Samples[0].Timestamp})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got series\n%v\nwant\n%v", got, want)
+	}
+	if samples := testutil.ToFloat64(w.samplesTotal); samples != 5 {
+		t.Errorf("samples sent = %g, want 5", samples)
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		statuses []int
+		sent     float64
+		failed   float64
+		dropped  float64
+	}{
+		{"server error retried", []int{503, 429, 200}, 5, 2, 0},
+		{"client error dropped", []int{400}, 0, 0, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests := make(chan struct{}, 10)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statuses[0])
+				test.statuses = test.statuses[1:]
+				requests <- struct{}{}
+			}))
+			defer server.Close()
+
+			attempts := len(test.statuses)
+			w, err := New(Options{URL: server.URL, Timeout: time.Second, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(testFamilies(), nil, time.Unix(1600000000, 0)); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < attempts; i++ {
+				select {
+				case <-requests:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("got %d requests, want %d", i, attempts)
+				}
+			}
+			// Wait for the loop to finish the request.
+			for deadline := time.Now().Add(5 * time.Second); testutil.ToFloat64(w.pendingGauge) > 0 && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			w.Close()
+
+			if got := testutil.ToFloat64(w.samplesTotal); got != test.sent {
+				t.Errorf("samples sent = %g, want %g", got, test.sent)
+			}
+			if got := testutil.ToFloat64(w.requestsFailedTotal); got != test.failed {
+				t.Errorf("failed requests = %g, want %g", got, test.failed)
+			}
+			if got := testutil.ToFloat64(w.droppedTotal); got != test.dropped {
+				t.Errorf("dropped requests = %g, want %g", got, test.dropped)
+			}
+		})
+	}
+}
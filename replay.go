@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/archive"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runReplay renders archived records as OpenMetrics with one timestamped
+// sample per record, suitable for promtool tsdb create-blocks-from openmetrics.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("target", "", "Only replay records of this target")
+	module := flags.String("module", "", "Only replay records of this module")
+	since := flags.Duration("since", 0, "Only replay records younger than this")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] <archive file or directory>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	keep := func(record *collector.Record) bool {
+		if *target != "" && record.Target != *target {
+			return false
+		}
+		if *module != "" && record.Module != *module {
+			return false
+		}
+		return *since <= 0 || time.Since(record.End) <= *since
+	}
+	if err := replay(os.Stdout, flags.Args(), keep); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// replay writes the records kept in the archive files or directories at
+// paths to w as OpenMetrics.
+func replay(w io.Writer, paths []string, keep func(*collector.Record) bool) error {
+	families := map[string]*dto.MetricFamily{}
+	merge := func(record *collector.Record) error {
+		if !keep(record) {
+			return nil
+		}
+		return mergeRecord(families, record)
+	}
+
+	for _, path := range paths {
+		files, err := replayFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, name := range files {
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			err = archive.Read(file, merge)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoder := expfmt.NewEncoder(w, expfmt.FmtOpenMetrics)
+	for _, name := range names {
+		sortSeries(families[name])
+		if err := encoder.Encode(families[name]); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+func replayFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return archive.Files(path)
+	}
+	return []string{filepath.Clean(path)}, nil
+}
+
+func mergeRecord(families map[string]*dto.MetricFamily, record *collector.Record) error {
+	labels := prometheus.Labels{}
+	for name, value := range record.Labels {
+		labels[name] = value
+	}
+	labels["target"] = record.Target
+	labels["module"] = record.Module
+
+	registry := prometheus.NewRegistry()
+	if err := prometheus.WrapRegistererWith(labels, registry).Register(&collector.ResultsCollector{Results: record.Results}); err != nil {
+		return err
+	}
+	gathered, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+
+	timestamp := record.End.UnixNano() / int64(time.Millisecond)
+	for _, family := range gathered {
+		for _, metric := range family.Metric {
+			metric.TimestampMs = &timestamp
+		}
+		if existing, ok := families[family.GetName()]; ok {
+			existing.Metric = append(existing.Metric, family.Metric...)
+		} else {
+			families[family.GetName()] = family
+		}
+	}
+	return nil
+}
+
+// sortSeries orders the samples of a family by series and then by time, as
+// OpenMetrics requires the samples of a series to be contiguous and in
+// order. Of samples of a series with the same timestamp, the first is kept.
+func sortSeries(family *dto.MetricFamily) {
+	keys := make(map[*dto.Metric]string, len(family.Metric))
+	for _, metric := range family.Metric {
+		keys[metric] = seriesKey(metric)
+	}
+	sort.SliceStable(family.Metric, func(i, j int) bool {
+		a, b := family.Metric[i], family.Metric[j]
+		if keys[a] != keys[b] {
+			return keys[a] < keys[b]
+		}
+		return a.GetTimestampMs() < b.GetTimestampMs()
+	})
+
+	kept := family.Metric[:0]
+	for _, metric := range family.Metric {
+		if n := len(kept); n > 0 && keys[kept[n-1]] == keys[metric] && kept[n-1].GetTimestampMs() == metric.GetTimestampMs() {
+			continue
+		}
+		kept = append(kept, metric)
+	}
+	family.Metric = kept
+}
+
+// seriesKey identifies the series of a metric by its labels, which the
+// registry gathers sorted by name.
+func seriesKey(metric *dto.Metric) string {
+	var key strings.Builder
+	for _, label := range metric.Label {
+		key.WriteString(label.GetName())
+		key.WriteByte(0)
+		key.WriteString(label.GetValue())
+		key.WriteByte(0)
+	}
+	return key.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"github.com/fluepke/iperf3-exporter/archive"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"strings"
+	"testing"
+	"time"
+)
+
+func replayRecord(target string, end time.Time, bytes int) *collector.Record {
+	return &collector.Record{
+		Target: target,
+		Module: "download",
+		Start:  end.Add(-10 * time.Second),
+		End:    end,
+		Results: &collector.Iperf3Results{
+			Start: &collector.Iperf3Start{TestStart: &collector.Iperf3TestStart{Protocol: "TCP"}},
+			End: &collector.Iperf3End{
+				SummarySent: &collector.Iperf3SummarySent{Bytes: bytes},
+			},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	a, err := archive.Open(archive.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(1600000000, 0)
+	for _, record := range []*collector.Record{
+		replayRecord("b", base.Add(2*time.Minute), 4),
+		replayRecord("a", base.Add(time.Minute), 2),
+		replayRecord("b", base, 3),
+		replayRecord("a", base, 1),
+		// Written twice, e.g. by a second exporter sharing the archive.
+		replayRecord("a", base, 1),
+		replayRecord("c", base, 5),
+	} {
+		if err := a.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	keep := func(record *collector.Record) bool { return record.Target != "c" }
+	if err := replay(&output, []string{dir}, keep); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(output.String(), "# EOF\n") {
+		t.Errorf("output does not end with # EOF:\n%s", output.String())
+	}
+
+	var samples []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.HasPrefix(line, "iperf3_sum_sent_bytes{") {
+			samples = append(samples, line)
+		}
+	}
+	want := []string{
+		`iperf3_sum_sent_bytes{module="download",target="a"} 1.0 1.6e+09`,
+		`iperf3_sum_sent_bytes{module="download",target="a"} 2.0 1.60000006e+09`,
+		`iperf3_sum_sent_bytes{module="download",target="b"} 3.0 1.6e+09`,
+		`iperf3_sum_sent_bytes{module="download",target="b"} 4.0 1.60000012e+09`,
+	}
+	if got := strings.Join(samples, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("samples of iperf3_sum_sent_bytes:\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/fluepke/iperf3-exporter/resolver"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var targetResolver *resolver.Resolver
+
+// expansionLabels tell apart the collectors a probe expands to, or the
+// probes of different modules.
+var expansionLabels = []string{"resolved_ip", "resolved_port", "ip_version", "netns", "dscp", "congestion_control"}
+
+// expand returns the collectors to probe the target with. If the module
+// resolves targets, there is one per probed address, labelled with
+// resolved_ip and resolved_port. Dual-stack probes test IPv4 and IPv6 one
+// after another, labelled with ip_version. QoS and congestion control
+// comparisons repeat each of these for every DSCP class or algorithm,
+// labelled with dscp or congestion_control.
+func (p *probe) expand(ctx context.Context) ([]*collector.Collector, error) {
+	if p.module.Netns != "" {
+		p.Labels = mergeLabels(p.Labels, map[string]string{"netns": p.module.Netns})
+	}
+
+	collectors, err := p.expandTarget(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collectors = repeat(collectors, "dscp", p.module.QoSClasses, func(c *collector.Collector, class string) {
+		dscp, _ := config.ParseDSCP(class)
+		c.TOS = dscp << 2
+	})
+	collectors = repeat(collectors, "congestion_control", p.module.CongestionControls, func(c *collector.Collector, algorithm string) {
+		c.CongestionControl = algorithm
+	})
+	return collectors, nil
+}
+
+// repeat returns a copy of every collector for each value, labelled with it
+// and adjusted by apply, or the collectors as they are if there are no
+// values.
+func repeat(collectors []*collector.Collector, label string, values []string, apply func(*collector.Collector, string)) []*collector.Collector {
+	if len(values) == 0 {
+		return collectors
+	}
+	repeated := make([]*collector.Collector, 0, len(collectors)*len(values))
+	for _, c := range collectors {
+		for _, value := range values {
+			v := *c
+			apply(&v, value)
+			v.Labels = mergeLabels(c.Labels, map[string]string{label: strings.ToLower(value)})
+			repeated = append(repeated, &v)
+		}
+	}
+	return repeated
+}
+
+func (p *probe) expandTarget(ctx context.Context) ([]*collector.Collector, error) {
+	if p.module.Resolve != "" {
+		return p.expandAddresses(ctx)
+	}
+
+	switch {
+	case p.module.DualStack:
+		return []*collector.Collector{
+			p.variant("", 4, map[string]string{"ip_version": "4"}),
+			p.variant("", 6, map[string]string{"ip_version": "6"}),
+		}, nil
+	case p.module.IPProtocol == config.IPProtocol4:
+		p.IPVersion = 4
+	case p.module.IPProtocol == config.IPProtocol6:
+		p.IPVersion = 6
+	case p.module.IPProtocol == config.IPProtocolPrefer:
+		// Without resolve, the addresses are only looked up to pick the
+		// family; iperf3 resolves the target again.
+		addresses, err := targetResolver.Resolve(ctx, p.Target, "", false)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"target": p.Target,
+			}).Debug("Resolving target to pick the IP protocol failed, leaving it to iperf3")
+			break
+		}
+		p.IPVersion = preferredVersion(addresses, p.module)
+	}
+	return []*collector.Collector{p.Collector}, nil
+}
+
+func (p *probe) expandAddresses(ctx context.Context) ([]*collector.Collector, error) {
+	port := ""
+	if p.module.Port != 0 {
+		port = strconv.Itoa(p.module.Port)
+	}
+	resolved, err := targetResolver.Resolve(ctx, p.Target, port, p.module.SRV)
+	var addresses []resolver.Address
+	if err == nil {
+		addresses, err = filterAddresses(resolved, p.module)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":    err,
+			"target": p.Target,
+		}).Error("Resolving target failed")
+		exporterMetrics.error(p.Target, p.Module)
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"target":    p.Target,
+		"resolved":  len(resolved),
+		"addresses": len(addresses),
+	}).Debug("Resolved target")
+
+	collectors := make([]*collector.Collector, 0, len(addresses))
+	for _, address := range addresses {
+		labels := map[string]string{
+			"resolved_ip":   address.IP,
+			"resolved_port": address.Port,
+		}
+		if p.module.DualStack {
+			labels["ip_version"] = strconv.Itoa(address.Version())
+		}
+		collectors = append(collectors, p.variant(address.Target(), address.Version(), labels))
+	}
+	return collectors, nil
+}
+
+// filterAddresses keeps the addresses of the families selected by the
+// module, and of each family the first one with resolve first or the first
+// MaxAddresses with resolve all.
+func filterAddresses(addresses []resolver.Address, module *config.Module) ([]resolver.Address, error) {
+	version := 0
+	switch module.IPProtocol {
+	case config.IPProtocol4:
+		version = 4
+	case config.IPProtocol6:
+		version = 6
+	case config.IPProtocolPrefer:
+		version = preferredVersion(addresses, module)
+	}
+
+	limit := module.MaxAddresses
+	if module.Resolve == config.ResolveFirst {
+		limit = 1
+	}
+	filtered := []resolver.Address{}
+	count := map[int]int{}
+	for _, address := range addresses {
+		if version != 0 && address.Version() != version {
+			continue
+		}
+		family := 0
+		if module.DualStack {
+			family = address.Version()
+		}
+		if limit > 0 && count[family] >= limit {
+			continue
+		}
+		count[family]++
+		filtered = append(filtered, address)
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("no IPv%d address", version)
+	}
+	return filtered, nil
+}
+
+// preferredVersion returns the preferred IP version of the module if there
+// is an address of that family, and the other one otherwise.
+func preferredVersion(addresses []resolver.Address, module *config.Module) int {
+	preferred, fallback := 6, 4
+	if module.PreferredIPProtocol == config.IPProtocol4 {
+		preferred, fallback = 4, 6
+	}
+	for _, address := range addresses {
+		if address.Version() == preferred {
+			return preferred
+		}
+	}
+	return fallback
+}
+
+// variant returns a copy of the collector probing address with the given IP
+// version and additional labels.
+func (p *probe) variant(address string, ipVersion int, labels map[string]string) *collector.Collector {
+	c := *p.Collector
+	c.Address = address
+	c.IPVersion = ipVersion
+	c.Labels = mergeLabels(p.Labels, labels)
+	return &c
+}
+
+// mergeLabels returns a copy of labels with additional ones.
+func mergeLabels(labels map[string]string, additional map[string]string) map[string]string {
+	merged := map[string]string{}
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range additional {
+		merged[name] = value
+	}
+	return merged
+}
+
+// probeLabels returns the labels telling apart the collectors of an
+// expanded probe.
+func probeLabels(c *collector.Collector) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, name := range expansionLabels {
+		if value, ok := c.Labels[name]; ok {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// single returns the only collector the target expands to, for handlers
+// that report a single result. If expanding fails, or the probe expands to
+// several tests, which only /probe reports, it responds with an error and
+// returns false.
+func (p *probe) single(w http.ResponseWriter, request *http.Request) (*collector.Collector, bool) {
+	collectors, err := p.expand(request.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil, false
+	}
+	if len(collectors) > 1 {
+		http.Error(w, fmt.Sprintf("Module %q runs %d tests per probe, which only /probe reports", p.Module, len(collectors)), http.StatusBadRequest)
+		return nil, false
+	}
+	return collectors[0], true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/fluepke/iperf3-exporter/resolver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilterAddresses(t *testing.T) {
+	addresses := []resolver.Address{
+		{IP: "2001:db8::1", Port: "5201"},
+		{IP: "192.0.2.1", Port: "5201"},
+		{IP: "2001:db8::2", Port: "5201"},
+		{IP: "192.0.2.2", Port: "5201"},
+		{IP: "192.0.2.3", Port: "5201"},
+	}
+	for _, test := range []struct {
+		name   string
+		module config.Module
+		want   string
+	}{
+		{"first", config.Module{Resolve: config.ResolveFirst}, "2001:db8::1"},
+		{"first dual stack", config.Module{Resolve: config.ResolveFirst, DualStack: true}, "2001:db8::1 192.0.2.1"},
+		{"first ip4", config.Module{Resolve: config.ResolveFirst, IPProtocol: config.IPProtocol4}, "192.0.2.1"},
+		{"all", config.Module{Resolve: config.ResolveAll}, "2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2 192.0.2.3"},
+		{"all limited", config.Module{Resolve: config.ResolveAll, MaxAddresses: 2}, "2001:db8::1 192.0.2.1"},
+		{"all limited per family", config.Module{Resolve: config.ResolveAll, MaxAddresses: 2, DualStack: true}, "2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2"},
+		{"all ip4 limited", config.Module{Resolve: config.ResolveAll, MaxAddresses: 2, IPProtocol: config.IPProtocol4}, "192.0.2.1 192.0.2.2"},
+		{"all prefer ip4", config.Module{Resolve: config.ResolveAll, IPProtocol: config.IPProtocolPrefer, PreferredIPProtocol: config.IPProtocol4}, "192.0.2.1 192.0.2.2 192.0.2.3"},
+	} {
+		filtered, err := filterAddresses(addresses, &test.module)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var ips []string
+		for _, address := range filtered {
+			ips = append(ips, address.IP)
+		}
+		if got := strings.Join(ips, " "); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	if _, err := filterAddresses(addresses[1:2], &config.Module{Resolve: config.ResolveAll, IPProtocol: config.IPProtocol6}); err == nil {
+		t.Error("filtering IPv6 addresses of an IPv4 target succeeded, want error")
+	}
+}
+
+func TestSingle(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		module config.Module
+		status int
+	}{
+		{"single", config.Module{}, http.StatusOK},
+		{"dual stack", config.Module{DualStack: true}, http.StatusBadRequest},
+		{"congestion controls", config.Module{CongestionControls: []string{"cubic", "bbr"}}, http.StatusBadRequest},
+	} {
+		p := &probe{Collector: &collector.Collector{Target: "192.0.2.1", Module: "test"}, module: &test.module}
+		w := httptest.NewRecorder()
+		c, ok := p.single(w, httptest.NewRequest("GET", "/probe/json?target=192.0.2.1", nil))
+		if ok != (test.status == http.StatusOK) || w.Code != test.status {
+			t.Errorf("%s: got %t and status %d, want status %d", test.name, ok, w.Code, test.status)
+		}
+		if ok && c.Target != "192.0.2.1" {
+			t.Errorf("%s: got collector for %s, want 192.0.2.1", test.name, c.Target)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port iperf3 servers listen on by default.
+const DefaultPort = "5201"
+
+var tracer = otel.Tracer("github.com/fluepke/iperf3-exporter/resolver")
+
+// Address is a resolved address of a target.
+type Address struct {
+	IP   string
+	Port string
+}
+
+// Version returns 4 for IPv4 and 6 for IPv6 addresses.
+func (a Address) Version() int {
+	if ip := net.ParseIP(a.IP); ip != nil && ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// Target returns the address in the host:port form accepted as target.
+func (a Address) Target() string {
+	return net.JoinHostPort(a.IP, a.Port)
+}
+
+// Resolver resolves targets to addresses, optionally via SRV records.
+type Resolver struct {
+	resolver *net.Resolver
+}
+
+// New returns a resolver querying server (host:port), or the system
+// resolver if server is empty.
+func New(server string) *Resolver {
+	if server == "" {
+		return &Resolver{resolver: net.DefaultResolver}
+	}
+	return &Resolver{resolver: &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}}
+}
+
+// Resolve returns the addresses of target, which is a host name or IP
+// address with an optional port, defaulting to port. With srv set, the
+// _iperf3._tcp SRV records of the host are looked up first, in order of
+// priority and weight; if there are none, the host itself is resolved.
+func (r *Resolver) Resolve(ctx context.Context, target string, port string, srv bool) ([]Address, error) {
+	ctx, span := tracer.Start(ctx, "iperf3.resolve")
+	defer span.End()
+	span.SetAttributes(attribute.String("iperf3.target", target), attribute.Bool("iperf3.srv", srv))
+
+	if port == "" {
+		port = DefaultPort
+	}
+	addresses, err := r.resolve(ctx, target, port, srv)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("iperf3.addresses", len(addresses)))
+	return addresses, nil
+}
+
+func (r *Resolver) resolve(ctx context.Context, target string, defaultPort string, srv bool) ([]Address, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.Trim(target, "[]"), ""
+	}
+
+	if srv && port == "" && net.ParseIP(host) == nil {
+		_, records, err := r.resolver.LookupSRV(ctx, "iperf3", "tcp", host)
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return nil, err
+		}
+		if len(records) > 0 {
+			addresses := []Address{}
+			for _, record := range records {
+				resolved, err := r.lookup(ctx, strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+				if err != nil {
+					return nil, err
+				}
+				addresses = append(addresses, resolved...)
+			}
+			return addresses, nil
+		}
+	}
+
+	if port == "" {
+		port = defaultPort
+	}
+	return r.lookup(ctx, host, port)
+}
+
+func (r *Resolver) lookup(ctx context.Context, host, port string) ([]Address, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []Address{{IP: ip.String(), Port: port}}, nil
+	}
+	ips, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]Address, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, Address{IP: ip.IP.String(), Port: port})
+	}
+	return addresses, nil
+}
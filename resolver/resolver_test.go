@@ -0,0 +1,128 @@
+package resolver
+
+import (
+	"context"
+	"github.com/fluepke/iperf3-exporter/internal/dnstest"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestResolveAddress(t *testing.T) {
+	r := New("")
+	for _, test := range []struct {
+		target string
+		port   string
+		srv    bool
+		want   []Address
+	}{
+		{"192.0.2.1", "", false, []Address{{"192.0.2.1", DefaultPort}}},
+		{"192.0.2.1", "5202", false, []Address{{"192.0.2.1", "5202"}}},
+		{"192.0.2.1:5203", "5202", false, []Address{{"192.0.2.1", "5203"}}},
+		{"2001:db8::1", "", false, []Address{{"2001:db8::1", DefaultPort}}},
+		{"[2001:db8::1]", "", false, []Address{{"2001:db8::1", DefaultPort}}},
+		{"[2001:db8::1]:5203", "", true, []Address{{"2001:db8::1", "5203"}}},
+		// SRV records are not looked up for addresses.
+		{"192.0.2.1", "", true, []Address{{"192.0.2.1", DefaultPort}}},
+	} {
+		got, err := r.Resolve(context.Background(), test.target, test.port, test.srv)
+		if err != nil {
+			t.Errorf("Resolve(%s): %s", test.target, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Resolve(%s, %q) = %v, want %v", test.target, test.port, got, test.want)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	for _, test := range []struct {
+		address Address
+		version int
+		target  string
+	}{
+		{Address{"192.0.2.1", "5201"}, 4, "192.0.2.1:5201"},
+		{Address{"2001:db8::1", "5201"}, 6, "[2001:db8::1]:5201"},
+		{Address{"::ffff:192.0.2.1", "5201"}, 4, "[::ffff:192.0.2.1]:5201"},
+	} {
+		if got := test.address.Version(); got != test.version {
+			t.Errorf("%v.Version() = %d, want %d", test.address, got, test.version)
+		}
+		if got := test.address.Target(); got != test.target {
+			t.Errorf("%v.Target() = %s, want %s", test.address, got, test.target)
+		}
+	}
+}
+
+func TestResolveNames(t *testing.T) {
+	server := dnstest.NewServer(t, dnstest.Zone{
+		A: map[string][]string{
+			"iperf3.test.": {"192.0.2.1", "192.0.2.2"},
+			"ip4.test.":    {"192.0.2.3"},
+			"dual.test.":   {"192.0.2.4"},
+			"first.test.":  {"192.0.2.10"},
+			"second.test.": {"192.0.2.20"},
+			"third.test.":  {"192.0.2.30"},
+		},
+		AAAA: map[string][]string{
+			"ip6.test.":  {"2001:db8::1"},
+			"dual.test.": {"2001:db8::4"},
+		},
+		SRV: map[string][]net.SRV{
+			"_iperf3._tcp.iperf3.test.": {
+				{Target: "third.test.", Port: 5203, Priority: 20, Weight: 100},
+				{Target: "second.test.", Port: 5202, Priority: 10, Weight: 0},
+				{Target: "first.test.", Port: 5201, Priority: 10, Weight: 50},
+			},
+			"_iperf3._tcp.missing.test.": {
+				{Target: "missing.test.", Port: 5201},
+			},
+		},
+	})
+	r := New(server.Addr)
+	for _, test := range []struct {
+		name   string
+		target string
+		srv    bool
+		want   []Address
+		err    bool
+	}{
+		// Within a priority, a record with a weight of 0 always comes after
+		// those with a weight, so the order does not depend on chance.
+		{"srv ordered by priority and weight", "iperf3.test", true, []Address{
+			{"192.0.2.10", "5201"}, {"192.0.2.20", "5202"}, {"192.0.2.30", "5203"},
+		}, false},
+		{"port skips srv", "iperf3.test:5210", true, []Address{
+			{"192.0.2.1", "5210"}, {"192.0.2.2", "5210"},
+		}, false},
+		{"without srv", "iperf3.test", false, []Address{
+			{"192.0.2.1", DefaultPort}, {"192.0.2.2", DefaultPort},
+		}, false},
+		{"falls back to a", "ip4.test", true, []Address{{"192.0.2.3", DefaultPort}}, false},
+		{"falls back to aaaa", "ip6.test", true, []Address{{"2001:db8::1", DefaultPort}}, false},
+		{"a and aaaa", "dual.test:5202", false, []Address{
+			{"192.0.2.4", "5202"}, {"2001:db8::4", "5202"},
+		}, false},
+		{"unknown name", "unknown.test", false, nil, true},
+		{"srv target without addresses", "missing.test", true, nil, true},
+	} {
+		got, err := r.Resolve(context.Background(), test.target, "", test.srv)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v, want error %t", test.name, err, test.err)
+			continue
+		}
+		if !test.srv {
+			// The addresses of a name are ordered by the reachability of
+			// their families from this host.
+			sort.Slice(got, func(i, j int) bool { return got[i].IP < got[j].IP })
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Resolve(%s) = %v, want %v", test.name, test.target, got, test.want)
+		}
+	}
+	if server.Queries() == 0 {
+		t.Error("the DNS server was not queried")
+	}
+}
@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as a failure that retrying cannot fix, such as a
+// rejected request.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Backoff waits Min before the first retry and doubles the wait after every
+// further attempt, up to Max unless it is zero. At most MaxRetries retries
+// follow the first attempt, or any number if MaxRetries is negative.
+type Backoff struct {
+	Min        time.Duration
+	Max        time.Duration
+	MaxRetries int
+}
+
+// Do calls attempt until it succeeds, fails permanently, the retries are used
+// up or stop is closed, and returns the error of the last attempt.
+func (b Backoff) Do(stop <-chan struct{}, attempt func() error) error {
+	wait := b.Min
+	for retries := 0; ; retries++ {
+		err := attempt()
+		if err == nil || IsPermanent(err) || b.MaxRetries >= 0 && retries >= b.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return err
+		}
+		wait *= 2
+		if b.Max > 0 && wait > b.Max {
+			wait = b.Max
+		}
+	}
+}
+
+// CheckResponse consumes the body of a response and returns an error unless
+// its status is 2xx. Server errors and rate limiting are worth a retry, all
+// other statuses are permanent failures.
+func CheckResponse(response *http.Response) error {
+	if response.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, response.Body)
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	err := fmt.Errorf("%s: %s", response.Status, bytes.TrimSpace(message))
+	if response.StatusCode/100 == 5 || response.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return Permanent(err)
+}
@@ -0,0 +1,110 @@
+package retry
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoffDo(t *testing.T) {
+	failure := errors.New("failure")
+	for _, test := range []struct {
+		name       string
+		maxRetries int
+		failures   int
+		permanent  bool
+		attempts   int
+		ok         bool
+	}{
+		{"success", 3, 0, false, 1, true},
+		{"success after retries", 3, 2, false, 3, true},
+		{"retries used up", 2, 5, false, 3, false},
+		{"no retries", 0, 5, false, 1, false},
+		{"unlimited retries", -1, 10, false, 11, true},
+		{"permanent", 3, 5, true, 1, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := Backoff{Min: time.Microsecond, MaxRetries: test.maxRetries}.Do(nil, func() error {
+				attempts++
+				if attempts <= test.failures {
+					if test.permanent {
+						return Permanent(failure)
+					}
+					return failure
+				}
+				return nil
+			})
+			if attempts != test.attempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.attempts)
+			}
+			if (err == nil) != test.ok {
+				t.Errorf("Do() = %v, want ok %t", err, test.ok)
+			}
+			if err != nil && !errors.Is(err, failure) {
+				t.Errorf("Do() = %v, want the error of the last attempt", err)
+			}
+		})
+	}
+}
+
+func TestBackoffDoWait(t *testing.T) {
+	var times []time.Time
+	Backoff{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond, MaxRetries: 3}.Do(nil, func() error {
+		times = append(times, time.Now())
+		return errors.New("failure")
+	})
+	if len(times) != 4 {
+		t.Fatalf("got %d attempts, want 4", len(times))
+	}
+	// The waits are 10ms, 20ms and 20ms capped by Max.
+	for i, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond} {
+		if got := times[i+1].Sub(times[i]); got < want || got > want+500*time.Millisecond {
+			t.Errorf("wait before attempt %d = %s, want %s", i+2, got, want)
+		}
+	}
+}
+
+func TestBackoffDoStop(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+	attempts := 0
+	err := Backoff{Min: time.Hour, MaxRetries: -1}.Do(stop, func() error {
+		attempts++
+		return errors.New("failure")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Do() = %v after %d attempts, want the error of the only attempt", err, attempts)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	for _, test := range []struct {
+		status    int
+		ok        bool
+		permanent bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusNoContent, true, false},
+		{http.StatusInternalServerError, false, false},
+		{http.StatusServiceUnavailable, false, false},
+		{http.StatusTooManyRequests, false, false},
+		{http.StatusBadRequest, false, true},
+		{http.StatusUnauthorized, false, true},
+		{http.StatusNotFound, false, true},
+		{http.StatusRequestEntityTooLarge, false, true},
+	} {
+		recorder := httptest.NewRecorder()
+		recorder.WriteHeader(test.status)
+		recorder.WriteString("message\n")
+		err := CheckResponse(recorder.Result())
+		if (err == nil) != test.ok || IsPermanent(err) != test.permanent {
+			t.Errorf("CheckResponse(%d) = %v, want ok %t and permanent %t", test.status, err, test.ok, test.permanent)
+		}
+		if err != nil && err.Error() != recorder.Result().Status+": message" {
+			t.Errorf("CheckResponse(%d) = %q, want the status and the message", test.status, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"github.com/fluepke/iperf3-exporter/config"
+	"github.com/fluepke/iperf3-exporter/discovery"
+	"github.com/fluepke/iperf3-exporter/remotewrite"
+	"github.com/fluepke/iperf3-exporter/scheduler"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	probeScheduler *scheduler.Scheduler
+	remoteWriter   *remotewrite.Writer
+	discoverers    []*discovery.File
+
+	jobsMu         sync.Mutex
+	staticJobs     []scheduler.Job
+	discoveredJobs [][]scheduler.Job
+)
+
+func startScheduler() {
+	if *remoteWriteURL != "" {
+		opts := remotewrite.Options{
+			URL:        *remoteWriteURL,
+			Username:   *remoteWriteUsername,
+			Timeout:    *remoteWriteTimeout,
+			WALDir:     *remoteWriteWALDir,
+			MaxPending: *remoteWriteMaxPending,
+			MaxAge:     *remoteWriteMaxAge,
+			MinBackoff: time.Second,
+			MaxBackoff: time.Minute,
+		}
+		var err error
+		if opts.BearerToken, err = readSecret(*remoteWriteBearerTokenFile); err == nil {
+			opts.Password, err = readSecret(*remoteWritePasswordFile)
+		}
+		if err == nil {
+			remoteWriter, err = remotewrite.New(opts)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("Could not set up remote write")
+		}
+		prometheus.MustRegister(remoteWriter)
+	}
+
+	if len(conf.Schedules) == 0 && len(conf.FileSD) == 0 {
+		if remoteWriter != nil {
+			log.Warn("Remote write is configured, but there are no scheduled probes")
+		}
+		return
+	}
+
+	probeScheduler = scheduler.New(*schedulerConcurrency, runScheduledProbe)
+	for _, schedule := range conf.Schedules {
+		staticJobs = append(staticJobs, scheduler.Job{
+			Target:   schedule.Target,
+			Module:   schedule.Module,
+			Labels:   schedule.Labels,
+			Interval: schedule.Interval,
+		})
+	}
+	discoveredJobs = make([][]scheduler.Job, len(conf.FileSD))
+	syncJobs()
+	for i, sd := range conf.FileSD {
+		i, sd := i, sd
+		discoverers = append(discoverers, discovery.NewFile(sd.Files, sd.RefreshInterval, func(groups []*discovery.TargetGroup) {
+			jobs := fileSDJobs(sd, groups)
+			jobsMu.Lock()
+			discoveredJobs[i] = jobs
+			jobsMu.Unlock()
+			log.WithFields(log.Fields{
+				"files":   strings.Join(sd.Files, ","),
+				"targets": len(jobs),
+			}).Info("Discovered targets")
+			syncJobs()
+		}))
+	}
+}
+
+// syncJobs runs the static and discovered jobs.
+func syncJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs := append([]scheduler.Job{}, staticJobs...)
+	for _, discovered := range discoveredJobs {
+		jobs = append(jobs, discovered...)
+	}
+	probeScheduler.Sync(jobs)
+	log.WithFields(log.Fields{
+		"jobs": len(jobs),
+	}).Info("Synced scheduled probes")
+}
+
+// fileSDJobs turns discovered target groups into jobs. Labels starting with
+// "__" are reserved, as in Prometheus, and dropped.
+func fileSDJobs(sd *config.FileSD, groups []*discovery.TargetGroup) []scheduler.Job {
+	jobs := []scheduler.Job{}
+	for _, group := range groups {
+		labels := map[string]string{}
+		for name, value := range sd.Labels {
+			labels[name] = value
+		}
+		for name, value := range group.Labels {
+			if !strings.HasPrefix(name, "__") {
+				labels[name] = value
+			}
+		}
+		for _, target := range group.Targets {
+			jobs = append(jobs, scheduler.Job{
+				Target:   target,
+				Module:   sd.Module,
+				Labels:   labels,
+				Interval: sd.Interval,
+			})
+		}
+	}
+	return jobs
+}
+
+func stopScheduler() {
+	for _, discoverer := range discoverers {
+		discoverer.Stop()
+	}
+	if probeScheduler != nil {
+		probeScheduler.Stop()
+	}
+	if remoteWriter != nil {
+		remoteWriter.Close()
+	}
+}
+
+func runScheduledProbe(ctx context.Context, job scheduler.Job) {
+	module, ok := conf.Modules[job.Module]
+	if !ok {
+		log.WithFields(log.Fields{
+			"target": job.Target,
+			"module": job.Module,
+		}).Error("Scheduled probe uses unknown module")
+		return
+	}
+	iperf3Collector := newModuleCollector(job.Target, job.Module, module)
+	iperf3Collector.Labels = job.Labels
+
+	collectors, err := iperf3Collector.expand(ctx)
+	if err != nil {
+		if remoteWriter != nil {
+			pushResults(job, nil, nil, time.Now())
+		}
+		return
+	}
+	for _, c := range collectors {
+		start := time.Now()
+		results, _ := c.Run(ctx)
+		iperf3DurationSummary.Observe(time.Since(start).Seconds())
+
+		if remoteWriter != nil {
+			pushResults(job, c.Labels, results, start)
+		}
+	}
+}
+
+// pushResults sends the metrics of a scheduled probe via remote write,
+// timestamped with the start of the probe. labels override those of the job.
+func pushResults(job scheduler.Job, labels map[string]string, results *collector.Iperf3Results, start time.Time) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&collector.ResultsCollector{Results: results})
+	families, err := registry.Gather()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Gathering scheduled probe metrics failed")
+		return
+	}
+
+	extra := map[string]string{}
+	for name, value := range job.Labels {
+		extra[name] = value
+	}
+	for name, value := range labels {
+		extra[name] = value
+	}
+	extra["job"] = *remoteWriteJob
+	extra["instance"] = job.Target
+	extra["module"] = job.Module
+
+	if err := remoteWriter.Write(families, extra, start); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Queueing remote write request failed")
+	}
+}
+
+func readSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
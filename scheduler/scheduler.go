@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job probes a target with a module every interval.
+type Job struct {
+	Target   string
+	Module   string
+	Labels   map[string]string
+	Interval time.Duration
+}
+
+// Key identifies a job by its target, module, labels and interval.
+func (j Job) Key() string {
+	names := make([]string, 0, len(j.Labels))
+	for name := range j.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(j.Target)
+	b.WriteByte(0)
+	b.WriteString(j.Module)
+	b.WriteByte(0)
+	b.WriteString(j.Interval.String())
+	for _, name := range names {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(j.Labels[name])
+	}
+	return b.String()
+}
+
+// ProbeFunc runs a single probe of a job. Once called, the scheduler holds
+// one of its concurrency slots until it returns.
+type ProbeFunc func(ctx context.Context, job Job)
+
+// Scheduler runs jobs periodically, at most concurrency probes at a time.
+type Scheduler struct {
+	probe ProbeFunc
+	slots chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func New(concurrency int, probe ProbeFunc) *Scheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{
+		probe: probe,
+		slots: make(chan struct{}, concurrency),
+		jobs:  map[string]context.CancelFunc{},
+	}
+}
+
+// Sync starts jobs that are not running yet and stops running jobs that
+// are no longer in jobs.
+func (s *Scheduler) Sync(jobs []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := map[string]Job{}
+	for _, job := range jobs {
+		wanted[job.Key()] = job
+	}
+
+	for key, cancel := range s.jobs {
+		if _, ok := wanted[key]; !ok {
+			cancel()
+			delete(s.jobs, key)
+		}
+	}
+	for key, job := range wanted {
+		if _, ok := s.jobs[key]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.jobs[key] = cancel
+		s.wg.Add(1)
+		go s.run(ctx, job)
+	}
+}
+
+// Stop stops all jobs and waits for running probes to finish.
+func (s *Scheduler) Stop() {
+	s.Sync(nil)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(offset(job))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(job.Interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case s.slots <- struct{}{}:
+		}
+		s.probe(ctx, job)
+		<-s.slots
+	}
+}
+
+// offset spreads the first probe of jobs evenly over their interval, stable
+// across restarts.
+func offset(job Job) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(job.Key()))
+	return time.Duration(h.Sum64() % uint64(job.Interval))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/archive"
+	"github.com/fluepke/iperf3-exporter/history"
+	"github.com/fluepke/iperf3-exporter/output"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// closers flush and release the sinks on shutdown.
+var closers []func()
+
+func setupSinks() {
+	if *archiveDir != "" {
+		resultArchive, err := archive.Open(archive.Options{
+			Dir:          *archiveDir,
+			MaxFileSize:  *archiveMaxFileSize,
+			MaxFileAge:   *archiveMaxFileAge,
+			Retention:    *archiveRetention,
+			MaxTotalSize: *archiveMaxSize,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("Could not open archive")
+		}
+		sinks = append(sinks, resultArchive)
+		closers = append(closers, func() { resultArchive.Close() })
+	}
+
+	if *historyPath != "" {
+		store, err := history.Open(*historyPath, *historyRetention)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("Could not open history database")
+		}
+		sinks = append(sinks, store)
+		closers = append(closers, func() { store.Close() })
+		registerHistoryAPI(store)
+	}
+
+	batchOptions := output.BatchOptions{
+		BatchSize:     *outputBatchSize,
+		FlushInterval: *outputFlushInterval,
+		MaxBuffered:   *outputMaxBuffered,
+		MaxRetries:    *outputMaxRetries,
+		RetryBackoff:  time.Second,
+		Timeout:       *outputTimeout,
+	}
+	if *influxDBURL != "" {
+		influxDB := output.NewInfluxDB(*influxDBURL, *influxDBToken, batchOptions)
+		sinks = append(sinks, influxDB)
+		closers = append(closers, influxDB.Close)
+	}
+	if *openTSDBURL != "" {
+		openTSDB := output.NewOpenTSDB(*openTSDBURL, batchOptions)
+		sinks = append(sinks, openTSDB)
+		closers = append(closers, openTSDB.Close)
+	}
+	if *otlpEndpoint != "" {
+		otlp, err := newOTLP(batchOptions)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Fatal("Could not set up OTLP export")
+		}
+		sinks = append(sinks, otlp)
+		closers = append(closers, otlp.Close)
+	}
+}
+
+func newOTLP(batchOptions output.BatchOptions) (*output.OTLP, error) {
+	headers, err := parseKeyValues(*otlpHeaders)
+	if err != nil {
+		return nil, err
+	}
+	return output.NewOTLP(output.OTLPOptions{
+		Endpoint: *otlpEndpoint,
+		Protocol: *otlpProtocol,
+		Insecure: *otlpInsecure,
+		Headers:  headers,
+	}, batchOptions)
+}
+
+// parseKeyValues parses comma separated key=value pairs.
+func parseKeyValues(s string) (map[string]string, error) {
+	pairs := map[string]string{}
+	if s == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid key=value pair %q", pair)
+		}
+		pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return pairs, nil
+}
+
+func closeSinks() {
+	for _, close := range closers {
+		close()
+	}
+}
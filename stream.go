@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fluepke/iperf3-exporter/collector"
+	"net/http"
+	"time"
+)
+
+// handleProbeStreamRequest runs a test with iperf3 --json-stream and relays
+// its events as Server-Sent Events. The assembled results are sent as a
+// final "result" event, or a "failure" event if the test failed.
+func handleProbeStreamRequest(w http.ResponseWriter, request *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	probe, ok := newCollector(w, request)
+	if !ok {
+		return
+	}
+	iperf3Collector, ok := probe.single(w, request)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	iperf3Collector.OnEvent = func(event *collector.Iperf3Event) {
+		writeEvent(w, event.Event, event.Data)
+		flusher.Flush()
+	}
+
+	start := time.Now()
+	results, err := iperf3Collector.Run(request.Context())
+	iperf3DurationSummary.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		data, _ := json.Marshal(err.Error())
+		writeEvent(w, "failure", data)
+	} else {
+		data, _ := json.Marshal(results)
+		writeEvent(w, "result", data)
+	}
+	flusher.Flush()
+}
+
+func writeEvent(w http.ResponseWriter, event string, data []byte) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var tracer = otel.Tracer("github.com/fluepke/iperf3-exporter")
+
+// setupTracing exports spans to the configured OTLP endpoint. Incoming W3C
+// trace context is honoured either way, so probes join the caller's trace.
+func setupTracing() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if *tracingEndpoint == "" {
+		return
+	}
+
+	exporter, err := newTraceExporter()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Could not set up trace export")
+	}
+
+	hostname, _ := os.Hostname()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*tracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "iperf3-exporter"),
+			attribute.String("service.version", version),
+			attribute.String("host.name", hostname),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("Exporting traces failed")
+	}))
+	closers = append(closers, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), *outputTimeout)
+		defer cancel()
+		provider.Shutdown(ctx)
+	})
+}
+
+func newTraceExporter() (*otlptrace.Exporter, error) {
+	headers, err := parseKeyValues(*tracingHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	switch *tracingProtocol {
+	case "grpc":
+		options := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(*tracingEndpoint),
+			otlptracegrpc.WithHeaders(headers),
+		}
+		if *tracingInsecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(context.Background(), options...)
+	case "http/protobuf":
+		endpoint, err := url.Parse(*tracingEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		options := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(endpoint.Host),
+			otlptracehttp.WithURLPath(endpoint.Path),
+			otlptracehttp.WithHeaders(headers),
+		}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", *tracingProtocol)
+	}
+}
+
+// traced wraps a handler in a server span, continuing the trace of the
+// request if it carries W3C trace context.
+func traced(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", request.Method),
+				attribute.String("http.target", request.URL.RequestURI()),
+				attribute.String("net.peer.name", request.RemoteAddr),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, request.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	}
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
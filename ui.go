@@ -0,0 +1,91 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed ui
+var uiAssets embed.FS
+
+func registerUI() {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fileServer := http.FileServer(http.FS(assets))
+
+	http.HandleFunc("/", func(w http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/" {
+			http.NotFound(w, request)
+			return
+		}
+		fileServer.ServeHTTP(w, request)
+	})
+	http.Handle("/static/", http.StripPrefix("/static/", fileServer))
+	http.HandleFunc("/api/modules", handleModulesRequest)
+}
+
+type moduleInfo struct {
+	Name         string  `json:"name"`
+	Duration     float64 `json:"duration"`
+	OmitDuration float64 `json:"omit_duration"`
+	Timeout      float64 `json:"timeout"`
+	MSS          int     `json:"mss"`
+	Reverse      bool    `json:"reverse"`
+}
+
+func handleModulesRequest(w http.ResponseWriter, request *http.Request) {
+	modules := []moduleInfo{}
+	for name, module := range conf.Modules {
+		modules = append(modules, moduleInfo{
+			Name:         name,
+			Duration:     module.Duration.Seconds(),
+			OmitDuration: module.OmitDuration.Seconds(),
+			Timeout:      module.ProbeTimeout().Seconds(),
+			MSS:          module.MSS,
+			Reverse:      module.Reverse,
+		})
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Name < modules[j].Name })
+
+	writeJSON(w, struct {
+		Version string       `json:"version"`
+		Modules []moduleInfo `json:"modules"`
+	}{version, modules})
+}
+
+func handleProbeJSONRequest(w http.ResponseWriter, request *http.Request) {
+	probe, ok := newCollector(w, request)
+	if !ok {
+		return
+	}
+	iperf3Collector, ok := probe.single(w, request)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	results, err := iperf3Collector.Run(request.Context())
+	iperf3DurationSummary.Observe(time.Since(start).Seconds())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, results)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Could not encode JSON response")
+	}
+}
@@ -0,0 +1,386 @@
+(function () {
+  "use strict";
+
+  var form = document.getElementById("probe-form");
+  var progress = document.getElementById("progress");
+  var errorBox = document.getElementById("error");
+  var result = document.getElementById("result");
+  var modules = {};
+  var timer = null;
+  var streamUnsupported = false;
+
+  fetch("/api/modules")
+    .then(function (response) { return response.json(); })
+    .then(function (data) {
+      document.getElementById("version").textContent = data.version;
+      var select = form.elements.module;
+      data.modules.forEach(function (module) {
+        modules[module.name] = module;
+        var option = document.createElement("option");
+        option.value = module.name;
+        option.textContent = module.name;
+        option.selected = module.name === "default";
+        select.appendChild(option);
+      });
+    })
+    .catch(function (err) { showError("Could not load modules: " + err); });
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+    startProbe(probeParams());
+  });
+
+  function probeParams() {
+    var params = new URLSearchParams();
+    ["target", "module", "duration", "omit-duration", "mss"].forEach(function (name) {
+      var value = form.elements[name].value.trim();
+      if (value !== "") {
+        params.set(name, value);
+      }
+    });
+    if (form.elements.reverse.checked) {
+      params.set("reverse", "true");
+    }
+    return params;
+  }
+
+  function expectedSeconds(params) {
+    var module = modules[params.get("module")] || {};
+    var duration = params.has("duration") ? parseDuration(params.get("duration")) : module.duration;
+    var omit = params.has("omit-duration") ? parseDuration(params.get("omit-duration")) : module.omit_duration;
+    return (duration || 0) + (omit || 0);
+  }
+
+  function parseDuration(value) {
+    var total = 0;
+    var re = /([0-9.]+)(ms|h|m|s)/g;
+    var match;
+    while ((match = re.exec(value)) !== null) {
+      total += parseFloat(match[1]) * { ms: 0.001, s: 1, m: 60, h: 3600 }[match[2]];
+    }
+    return total;
+  }
+
+  function startProbe(params) {
+    errorBox.hidden = true;
+    result.hidden = true;
+    form.querySelector("button").disabled = true;
+
+    var expected = expectedSeconds(params);
+    var started = Date.now();
+    result.querySelector(".cards").innerHTML = "";
+    var status = "Starting test against " + params.get("target") + " ...";
+    // Without events, progress is estimated from the expected duration.
+    var estimate = false;
+    setProgress(0, status);
+    progress.hidden = false;
+    timer = setInterval(function () {
+      var elapsed = (Date.now() - started) / 1000;
+      var text = status + " " + elapsed.toFixed(0) + "s";
+      if (!estimate) {
+        progress.querySelector(".status").textContent = text;
+      } else if (expected > 0) {
+        setProgress(Math.min(elapsed / expected, 1), text + " of ~" + expected.toFixed(0) + "s");
+      } else {
+        setProgress(0, text);
+      }
+    }, 500);
+
+    var fallback = function () {
+      estimate = true;
+      status = "Running test against " + params.get("target") + " ...";
+      started = Date.now();
+      probeJSON(params);
+    };
+    if (streamUnsupported) {
+      fallback();
+      return;
+    }
+    probeStream(params, {
+      start: function (testStart) {
+        if (testStart.duration) {
+          expected = testStart.duration + (testStart.omit || 0);
+        }
+        status = "Connected to " + params.get("target") + ", running test ...";
+      },
+      interval: function (interval) {
+        status = "Running test against " + params.get("target") + ": " +
+          formatBitrate(interval.sum.bits_per_second) + " in last interval ...";
+        if (expected > 0) {
+          setProgress(Math.min(interval.sum.end / expected, 1), status);
+        }
+      },
+      fallback: fallback,
+    });
+  }
+
+  // probeStream runs the test via /probe/stream. If it fails before iperf3
+  // started the test, e.g. because iperf3 is older than 3.17 and lacks
+  // --json-stream, the test is run again via /probe/json.
+  function probeStream(params, callbacks) {
+    var source = new EventSource("/probe/stream?" + params.toString());
+    var partial = { intervals: [] };
+    var finished = false;
+    var finish = function (results, err) {
+      finished = true;
+      source.close();
+      if (err && !partial.start) {
+        callbacks.fallback();
+        return;
+      }
+      finishProbe(params, results, err);
+    };
+
+    source.addEventListener("start", function (event) {
+      partial.start = JSON.parse(event.data);
+      callbacks.start(partial.start.test_start || {});
+    });
+    source.addEventListener("interval", function (event) {
+      var interval = JSON.parse(event.data);
+      partial.intervals.push(interval);
+      callbacks.interval(interval);
+      renderIntervals(partial.intervals);
+      result.hidden = false;
+    });
+    source.addEventListener("result", function (event) {
+      finish(JSON.parse(event.data));
+    });
+    source.addEventListener("failure", function (event) {
+      finish(null, new Error(JSON.parse(event.data)));
+    });
+    source.onerror = function () {
+      if (!finished) {
+        finish(null, new Error("connection to exporter lost"));
+      }
+    };
+  }
+
+  function probeJSON(params) {
+    fetch("/probe/json?" + params.toString())
+      .then(function (response) {
+        if (!response.ok) {
+          return response.text().then(function (text) { throw new Error(text.trim()); });
+        }
+        return response.json();
+      })
+      .then(function (results) {
+        // Streaming failed while a plain test works, so skip it from now on.
+        streamUnsupported = true;
+        finishProbe(params, results);
+      })
+      .catch(function (err) { finishProbe(params, null, err); });
+  }
+
+  function finishProbe(params, results, err) {
+    clearInterval(timer);
+    progress.hidden = true;
+    form.querySelector("button").disabled = false;
+    if (err) {
+      result.hidden = true;
+      showError("Test failed: " + err.message);
+      return;
+    }
+    renderResult(params, results);
+  }
+
+  function setProgress(fraction, status) {
+    progress.querySelector(".fill").style.width = (fraction * 100).toFixed(1) + "%";
+    progress.querySelector(".status").textContent = status;
+  }
+
+  function showError(message) {
+    errorBox.textContent = message;
+    errorBox.hidden = false;
+  }
+
+  function renderResult(params, results) {
+    document.getElementById("metrics-link").href = "/probe?" + params.toString();
+    var blob = new Blob([JSON.stringify(results, null, 2)], { type: "application/json" });
+    document.getElementById("json-link").href = URL.createObjectURL(blob);
+
+    renderCards(results);
+    renderIntervals(results.intervals || []);
+    result.hidden = false;
+  }
+
+  function renderIntervals(intervals) {
+    var omitted = intervals.filter(function (i) { return i.sum.omitted; }).map(function (i) {
+      return [i.sum.start, i.sum.end];
+    });
+
+    lineChart(document.getElementById("throughput-chart"), [
+      intervals.map(function (i) { return [i.sum.end, i.sum.bits_per_second / 1e6]; }),
+    ], "Mbit/s", omitted);
+
+    lineChart(document.getElementById("rtt-chart"), [
+      intervals.map(function (i) { return [i.sum.end, meanRtt(i.streams) / 1000]; })
+        .filter(function (p) { return !isNaN(p[1]); }),
+    ], "ms", omitted);
+
+    barChart(document.getElementById("retransmits-chart"),
+      intervals.map(function (i) { return [i.sum.start, i.sum.end, i.sum.retransmits || 0]; }),
+      "retransmits", omitted);
+  }
+
+  function renderCards(results) {
+    var end = results.end || {};
+    var sent = end.sum_sent || {};
+    var received = end.sum_received || {};
+    var cpu = end.cpu_utilization_percent || {};
+    var senders = (end.streams || []).map(function (s) { return s.sender; }).filter(Boolean);
+    var rtts = senders.filter(function (s) { return s.mean_rtt; });
+
+    var cards = [
+      ["Sent", formatBitrate(sent.bits_per_second)],
+      ["Received", formatBitrate(received.bits_per_second)],
+      ["Transferred", formatBytes(sent.bytes)],
+      ["Retransmits", sent.retransmits !== undefined ? sent.retransmits : "n/a"],
+    ];
+    if (rtts.length > 0) {
+      cards.push(["RTT min / mean / max", [
+        Math.min.apply(null, rtts.map(function (s) { return s.min_rtt; })),
+        rtts.reduce(function (sum, s) { return sum + s.mean_rtt; }, 0) / rtts.length,
+        Math.max.apply(null, rtts.map(function (s) { return s.max_rtt; })),
+      ].map(function (us) { return (us / 1000).toFixed(2); }).join(" / ") + " ms"]);
+    }
+    cards.push(["CPU host", formatPercent(cpu.host_total)]);
+    cards.push(["CPU remote", formatPercent(cpu.remote_total)]);
+    if (end.sender_tcp_congestion) {
+      cards.push(["Congestion control", end.sender_tcp_congestion + " / " + end.receiver_tcp_congestion]);
+    }
+
+    var container = result.querySelector(".cards");
+    container.innerHTML = "";
+    cards.forEach(function (card) {
+      var el = document.createElement("div");
+      el.className = "card";
+      var label = document.createElement("div");
+      label.className = "label";
+      label.textContent = card[0];
+      var value = document.createElement("div");
+      value.className = "value";
+      value.textContent = card[1];
+      el.appendChild(label);
+      el.appendChild(value);
+      container.appendChild(el);
+    });
+  }
+
+  function meanRtt(streams) {
+    var rtts = (streams || []).filter(function (s) { return s.rtt; }).map(function (s) { return s.rtt; });
+    if (rtts.length === 0) {
+      return NaN;
+    }
+    return rtts.reduce(function (a, b) { return a + b; }, 0) / rtts.length;
+  }
+
+  function formatBitrate(bps) {
+    if (bps === undefined) {
+      return "n/a";
+    }
+    var units = ["bit/s", "kbit/s", "Mbit/s", "Gbit/s", "Tbit/s"];
+    var i = 0;
+    while (bps >= 1000 && i < units.length - 1) {
+      bps /= 1000;
+      i++;
+    }
+    return bps.toFixed(2) + " " + units[i];
+  }
+
+  function formatBytes(bytes) {
+    if (bytes === undefined) {
+      return "n/a";
+    }
+    var units = ["B", "KiB", "MiB", "GiB", "TiB"];
+    var i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+      bytes /= 1024;
+      i++;
+    }
+    return bytes.toFixed(1) + " " + units[i];
+  }
+
+  function formatPercent(value) {
+    return value === undefined ? "n/a" : value.toFixed(1) + " %";
+  }
+
+  var svgNS = "http://www.w3.org/2000/svg";
+  var width = 720, height = 220, pad = { top: 10, right: 15, bottom: 25, left: 55 };
+
+  function svgElement(name, attrs, text) {
+    var el = document.createElementNS(svgNS, name);
+    Object.keys(attrs).forEach(function (key) { el.setAttribute(key, attrs[key]); });
+    if (text !== undefined) {
+      el.textContent = text;
+    }
+    return el;
+  }
+
+  function axes(svg, maxX, maxY, unit, omitted) {
+    var sx = function (x) { return pad.left + x / maxX * (width - pad.left - pad.right); };
+    var sy = function (y) { return height - pad.bottom - y / maxY * (height - pad.top - pad.bottom); };
+
+    omitted.forEach(function (range) {
+      svg.appendChild(svgElement("rect", {
+        "class": "omitted", x: sx(range[0]), y: pad.top,
+        width: sx(range[1]) - sx(range[0]), height: height - pad.top - pad.bottom,
+      }));
+    });
+    for (var i = 0; i <= 4; i++) {
+      var y = maxY * i / 4;
+      svg.appendChild(svgElement("line", { "class": "grid", x1: pad.left, x2: width - pad.right, y1: sy(y), y2: sy(y) }));
+      svg.appendChild(svgElement("text", { x: pad.left - 5, y: sy(y) + 4, "text-anchor": "end" }, y.toPrecision(3)));
+    }
+    svg.appendChild(svgElement("line", { "class": "axis", x1: pad.left, x2: pad.left, y1: pad.top, y2: height - pad.bottom }));
+    svg.appendChild(svgElement("line", { "class": "axis", x1: pad.left, x2: width - pad.right, y1: height - pad.bottom, y2: height - pad.bottom }));
+    svg.appendChild(svgElement("text", { x: pad.left + 5, y: pad.top + 10 }, unit));
+    svg.appendChild(svgElement("text", { x: width - pad.right, y: height - 5, "text-anchor": "end" }, maxX.toFixed(0) + "s"));
+    return { x: sx, y: sy };
+  }
+
+  function emptyChart(el) {
+    el.innerHTML = "<div class=\"empty\">No data</div>";
+  }
+
+  function lineChart(el, series, unit, omitted) {
+    var points = [].concat.apply([], series);
+    if (points.length === 0) {
+      emptyChart(el);
+      return;
+    }
+    var maxX = Math.max.apply(null, points.map(function (p) { return p[0]; })) || 1;
+    var maxY = Math.max.apply(null, points.map(function (p) { return p[1]; })) * 1.1 || 1;
+
+    var svg = svgElement("svg", { viewBox: "0 0 " + width + " " + height });
+    var scale = axes(svg, maxX, maxY, unit, omitted);
+    series.forEach(function (data, i) {
+      var d = data.map(function (p, j) {
+        return (j === 0 ? "M" : "L") + scale.x(p[0]).toFixed(1) + "," + scale.y(p[1]).toFixed(1);
+      }).join(" ");
+      svg.appendChild(svgElement("path", { "class": "series-" + i, d: d }));
+    });
+    el.innerHTML = "";
+    el.appendChild(svg);
+  }
+
+  function barChart(el, bars, unit, omitted) {
+    if (bars.length === 0) {
+      emptyChart(el);
+      return;
+    }
+    var maxX = Math.max.apply(null, bars.map(function (b) { return b[1]; })) || 1;
+    var maxY = Math.max.apply(null, bars.map(function (b) { return b[2]; })) * 1.1 || 1;
+
+    var svg = svgElement("svg", { viewBox: "0 0 " + width + " " + height });
+    var scale = axes(svg, maxX, maxY, unit, omitted);
+    bars.forEach(function (b) {
+      var x = scale.x(b[0]);
+      svg.appendChild(svgElement("rect", {
+        "class": "bar-0", x: x + 1, y: scale.y(b[2]),
+        width: Math.max(scale.x(b[1]) - x - 2, 1), height: scale.y(0) - scale.y(b[2]),
+      }));
+    });
+    el.innerHTML = "";
+    el.appendChild(svg);
+  }
+})();